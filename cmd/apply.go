@@ -0,0 +1,77 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan-id>",
+	Short: "Make the spot request from a plan saved by 'start --plan'",
+	Long: `
+Applies a plan saved by 'parsec-ec2 start --plan', making exactly the spot
+request that was reviewed rather than working out the spot price, availability
+zone and address again.
+
+The plan is refused if it has gone stale: if it is more than 30 minutes old,
+a session is already running, the spot price has risen above the planned bid,
+or your public IPv4 address is no longer the one the security group allows.
+Make and review a new plan with 'parsec-ec2 start --plan' if that happens.
+
+Saved plans hold the Parsec server key, so they are readable only by you and
+are removed once they have been applied or refused. Plans that are never
+applied are removed the next time a plan is made or applied or the status is
+checked after they have expired.
+
+Example:
+
+parsec-ec2 apply 3f9a1c2e
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := unlockIfForced(); err != nil {
+			return err
+		}
+
+		ctx, cancel := commandContext()
+		defer cancel()
+
+		fmt.Printf("Applying plan %s...\n", args[0])
+		result, err := client.ApplyPlan(ctx, args[0], ipResolver())
+		if err != nil {
+			return err
+		}
+
+		for _, warning := range result.Warnings {
+			fmt.Println(warning)
+		}
+
+		p := result.Session
+
+		fmt.Printf("Spot request made successfully for a %s instance in %s with a bid of $%s. Check the status of the spot request with 'parsec-ec2 status'.\n",
+			p.InstanceType, p.Region, p.SpotPrice)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(applyCmd)
+	addForceUnlockFlag(applyCmd)
+	applyCmd.Flags().StringVar(&ipOverride, "ip", "", "public IPv4 address the plan was made for, instead of looking it up")
+}
@@ -0,0 +1,373 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "View, change and check the settings in the config file",
+	Long: `
+Manages $HOME/.parsec-ec2.yaml, which holds defaults for the flags of other
+commands and named profiles of settings, such as:
+
+region: eu-west-1
+profiles:
+  weeknight:
+    instance_type: g2.2xlarge
+    bid: 0.05
+
+A profile is used with --profile, PARSEC_EC2_PROFILE or by setting profile
+in the config file. A setting given as a flag takes precedence over one in
+the environment, such as PARSEC_EC2_REGION, which takes precedence over the
+profile, which takes precedence over the top of the config file and then the
+built in defaults.
+
+Examples:
+
+parsec-ec2 config view
+parsec-ec2 config view --profile weeknight
+parsec-ec2 config set region eu-west-1
+parsec-ec2 config set --profile weeknight instance_type g2.2xlarge
+parsec-ec2 config set --profile weeknight tags owner=jade,team=games
+parsec-ec2 config validate
+`,
+	// A profile that doesn't exist yet can be created with config set.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return configErr
+	},
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show the settings in effect and where each one comes from",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if profileErr != nil {
+			return profileErr
+		}
+
+		if names := profileNames(); len(names) > 0 {
+			fmt.Printf("Profiles: %s\n", strings.Join(names, ", "))
+		}
+
+		if len(activeProfile) > 0 {
+			fmt.Printf("Using profile: %s\n", activeProfile)
+		}
+
+		fmt.Println()
+
+		var keys []string
+		for key := range configKeys {
+			if key != CfgProfile && viper.IsSet(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+		for _, key := range keys {
+			value := formatConfigValue(key)
+			if key == CfgServerKey && len(value) > 0 {
+				value = "********"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, configSource(key))
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <setting> <value>",
+	Short: "Change a setting in the config file, or in a profile with --profile",
+	Long: `
+Changes a setting in the config file, or in the profile given with --profile,
+creating the profile if needed. Lists such as stun_servers are given separated
+by commas, and maps such as tags as key=value pairs separated by commas.
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, value := strings.ToLower(args[0]), args[1]
+
+		if key == CfgServerKey {
+			return errors.New("The server key isn't kept in the config file. Store it in the OS keyring with 'parsec-ec2 key set'.")
+		}
+
+		if key == CfgProfile && len(profile) > 0 {
+			return errors.New("The profile setting can only be set at the top of the config file.")
+		}
+
+		if err := checkConfigValue(key, value); err != nil {
+			return err
+		}
+
+		path, err := configFilePath()
+		if err != nil {
+			return err
+		}
+
+		doc, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		setting := strings.Split(key, ".")
+		if len(profile) > 0 {
+			setting = append([]string{CfgProfiles, profile}, setting...)
+		}
+
+		setYAMLValue(doc, setting, configValueNode(key, value))
+
+		if err := writeConfigFile(path, doc); err != nil {
+			return err
+		}
+
+		fmt.Printf("Set %s to %s in %s.\n", strings.Join(setting, "."), value, path)
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check every setting and profile in the config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configFilePath()
+		if err != nil {
+			return err
+		}
+
+		doc, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		settings := map[string]interface{}{}
+		if len(doc.Content) > 0 {
+			if err := doc.Content[0].Decode(&settings); err != nil {
+				return err
+			}
+		}
+
+		errs := checkConfig(settings)
+		if len(errs) == 0 {
+			fmt.Printf("%s is valid.\n", path)
+			return nil
+		}
+
+		var problems []string
+		for _, err := range errs {
+			problems = append(problems, err.Error())
+		}
+
+		return fmt.Errorf("Found %d problem(s) in %s:\n  %s", len(errs), path, strings.Join(problems, "\n  "))
+	},
+}
+
+// checkConfig checks the settings at the top of the config file and in each
+// of its profiles.
+func checkConfig(settings map[string]interface{}) []error {
+	top := map[string]interface{}{}
+	for key, value := range settings {
+		if key != CfgProfiles {
+			top[key] = value
+		}
+	}
+
+	errs := checkSettings("", top)
+
+	all, ok := settings[CfgProfiles].(map[string]interface{})
+	if _, found := settings[CfgProfiles]; found && !ok {
+		return append(errs, fmt.Errorf("%s must be a map of profile names to settings.", CfgProfiles))
+	}
+
+	if name, ok := top[CfgProfile].(string); ok {
+		if _, found := all[name]; !found {
+			errs = append(errs, fmt.Errorf("The profile setting names %s, but there is no profile with that name.", name))
+		}
+	}
+
+	var names []string
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p, ok := all[name].(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("Profile %s must be a map of settings.", name))
+			continue
+		}
+
+		for _, err := range checkSettings("", p) {
+			errs = append(errs, fmt.Errorf("Profile %s: %s", name, err))
+		}
+
+		if _, found := p[CfgProfile]; found {
+			errs = append(errs, fmt.Errorf("Profile %s: the profile setting can only be set at the top of the config file.", name))
+		}
+	}
+
+	return errs
+}
+
+// configFilePath is the config file that was read, or where a new one is
+// created.
+func configFilePath() (string, error) {
+	if path := viper.ConfigFileUsed(); len(path) > 0 {
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return "", fmt.Errorf("%s is not a YAML file, so it can't be changed by parsec-ec2.", path)
+		}
+		return path, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s", home, ConfigFile), nil
+}
+
+// readConfigFile parses the config file, keeping its comments and the order
+// of its settings. A missing file is read as an empty document.
+func readConfigFile(path string) (*yaml.Node, error) {
+	doc := &yaml.Node{Kind: yaml.DocumentNode}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return doc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+
+	return doc, nil
+}
+
+// writeConfigFile writes the config file readable only by the user, since
+// it can hold a server key.
+func writeConfigFile(path string, doc *yaml.Node) error {
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Chmod(path, 0600)
+}
+
+// setYAMLValue sets the setting at path in a YAML document, creating the
+// maps on the way to it and keeping the comments of a value it replaces.
+func setYAMLValue(doc *yaml.Node, path []string, value *yaml.Node) {
+	if len(doc.Content) == 0 {
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.MappingNode})
+	}
+
+	node := doc.Content[0]
+	for i, key := range path {
+		last := i == len(path)-1
+
+		index := -1
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if strings.EqualFold(node.Content[j].Value, key) {
+				index = j + 1
+			}
+		}
+
+		next := value
+		if !last {
+			next = &yaml.Node{Kind: yaml.MappingNode}
+		}
+
+		switch {
+		case index < 0:
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+		case last || node.Content[index].Kind != yaml.MappingNode:
+			next.HeadComment = node.Content[index].HeadComment
+			next.LineComment = node.Content[index].LineComment
+			node.Content[index] = next
+		default:
+			next = node.Content[index]
+		}
+
+		node = next
+	}
+}
+
+// configValueNode is the YAML for a value given on the command line.
+func configValueNode(key, value string) *yaml.Node {
+	switch configKeys[key] {
+	case configFloat, configInt, configBool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	case configList:
+		list := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, v := range splitList(value) {
+			list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+		}
+		return list
+	case configMap:
+		m := &yaml.Node{Kind: yaml.MappingNode}
+		for _, pair := range splitList(value) {
+			kv := strings.SplitN(pair, "=", 2)
+			m.Content = append(m.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: strings.TrimSpace(kv[0])},
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSpace(kv[1])})
+		}
+		return m
+	}
+
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd, configSetCmd, configValidateCmd)
+}
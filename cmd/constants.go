@@ -1,86 +1,89 @@
 package cmd
 
-import (
-	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/service/ec2"
-)
-
-// Terraform command
-const Terraform = "terraform"
+import "time"
 
-// Terraform CLI Commands
+// Filenames
 const (
-	TfCmdApply   = "apply"
-	TfCmdDestroy = "destroy"
-	TfCmdInit    = "init"
-	TfCmdOutput  = "output"
-	TfCmdPlan    = "plan"
-	TfCmdRefresh = "refresh"
+	Schedules = "schedule.json"
+	History   = "history.jsonl"
 )
 
-// Parsec Terraform Template Outputs
+// Config Keys
 const (
-	TfOutputInstanceType   = "instance_type"
-	TfOutputRegion         = "region"
-	TfOutputServerKey      = "server_key"
-	TfOutputSpotInstanceID = "spot_instance_id"
-	TfOutputSpotPrice      = "spot_price"
-	TfOutputSubnetID       = "subnet_id"
-	TfOutputVpcID          = "vpc_id"
-)
+	CfgProfile      = "profile"
+	CfgProfiles     = "profiles"
+	CfgRegion       = "region"
+	CfgInstanceType = "instance_type"
+	CfgBid          = "bid"
 
-// Terraform CLI Command Flags
-const (
-	TfFlagForce = "-force"
-	TfFlagJSON  = "-json"
-)
+	CfgRootVolumeSize       = "root_volume_size"
+	CfgDataVolumeSize       = "data_volume_size"
+	CfgDataVolumeType       = "data_volume_type"
+	CfgDataVolumeIOPS       = "data_volume_iops"
+	CfgDataVolumeThroughput = "data_volume_throughput"
+	CfgEncryptVolumes       = "encrypt_volumes"
+	CfgKMSKeyID             = "kms_key_id"
 
-// Filenames
-const (
-	Template       = "parsec.tf"
-	Userdata       = "user_data.tmpl"
-	CurrentSession = "currentSession.json"
-)
+	CfgLatencyMethod   = "latency_method"
+	CfgLatencyProbes   = "latency_probes"
+	CfgLatencyCacheAge = "latency_cache_age"
+	CfgLatencyWeight   = "latency_weight"
 
-// Product Description and Instance Statuses
-const (
-	Windows = "Windows"
-	OK      = "ok"
+	CfgIPProviders = "ip_providers"
+	CfgIPTimeout   = "ip_timeout"
+	CfgSTUNServers = "stun_servers"
+	CfgServerKey   = "server_key"
+
+	CfgServerKeySource = "server_key_source"
+
+	CfgUser               = "user"
+	CfgMonthlyBudget      = "monthly_budget"
+	CfgBudgetAction       = "budget_action"
+	CfgBudgetSessionHours = "budget_session_hours"
+
+	CfgMaxSessionDuration = "max_session_duration"
+	CfgIdleTimeout        = "idle_timeout"
+
+	CfgTags = "tags"
+
+	CfgVpcID     = "vpc_id"
+	CfgCreateVpc = "create_vpc"
+
+	CfgAWSProfile         = "aws.profile"
+	CfgAWSRoleARN         = "aws.role_arn"
+	CfgAWSExternalID      = "aws.external_id"
+	CfgAWSMFASerial       = "aws.mfa_serial"
+	CfgAWSSessionDuration = "aws.session_duration"
+
+	CfgParsecStartPort  = "parsec.start_port"
+	CfgParsecBitrate    = "parsec.bitrate"
+	CfgParsecResolution = "parsec.resolution"
+	CfgParsecHostName   = "parsec.host_name"
+
+	CfgBackendType           = "backend.type"
+	CfgBackendBucket         = "backend.bucket"
+	CfgBackendKey            = "backend.key"
+	CfgBackendRegion         = "backend.region"
+	CfgBackendDynamoDBTable  = "backend.dynamodb_table"
+	CfgBackendAddress        = "backend.address"
+	CfgBackendSessionAddress = "backend.session_address"
+	CfgBackendLockAddress    = "backend.lock_address"
+	CfgBackendUnlockAddress  = "backend.unlock_address"
+	CfgBackendPath           = "backend.path"
 )
 
-func ec2Regions() map[string]endpoints.Region {
-	partition := endpoints.AwsPartition()
-	services := partition.Services()
-	ec2 := services[endpoints.Ec2ServiceID]
-	return ec2.Regions()
-}
-
-func isValidRegion(validRegions map[string]endpoints.Region, input string) bool {
-	for _, valid := range validRegions {
-		if input == valid.ID() {
-			return true
-		}
-	}
-	return false
-}
-
-func gInstances() []string {
-	return []string{
-		ec2.InstanceTypeG22xlarge,
-		ec2.InstanceTypeG28xlarge,
-		ec2.InstanceTypeG34xlarge,
-		ec2.InstanceTypeG38xlarge,
-		ec2.InstanceTypeG316xlarge,
-		ec2.InstanceTypeP32xlarge,
-		ec2.InstanceTypeG4dn2xlarge,
-	}
-}
-
-func isValidGInstance(validInstances []string, input string) bool {
-	for _, valid := range validInstances {
-		if input == valid {
-			return true
-		}
-	}
-	return false
-}
+// ConfigFile is the name of the config file in the home directory.
+const ConfigFile = ".parsec-ec2.yaml"
+
+// ProvisionLog is where the provisioning progress is logged on the instance.
+const ProvisionLog = `C:\ProgramData\parsec-ec2\provision.log`
+
+// ScheduleCatchUp is how far back the scheduler looks for scheduled actions
+// it missed while the machine was asleep.
+const ScheduleCatchUp = 24 * time.Hour
+
+// ServerKeySecret is the name of the Parsec server key in the OS keyring.
+const ServerKeySecret = "server_key"
+
+// Ledger Report Month Format
+const ReportMonthFormat = "2006-01"
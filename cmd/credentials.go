@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+var awsProfile, awsRoleARN string
+
+// awsConfig reads the AWS credential settings, with the flags taking
+// precedence over the config file and profile.
+func awsConfig(cmd *cobra.Command) parsec.AWSConfig {
+	config := parsec.AWSConfig{
+		Profile:         viper.GetString(CfgAWSProfile),
+		RoleARN:         viper.GetString(CfgAWSRoleARN),
+		ExternalID:      viper.GetString(CfgAWSExternalID),
+		MFASerial:       viper.GetString(CfgAWSMFASerial),
+		SessionDuration: viper.GetDuration(CfgAWSSessionDuration),
+		TokenProvider:   mfaToken,
+	}
+
+	if cmd.Flags().Changed("aws-profile") {
+		config.Profile = awsProfile
+	}
+
+	if cmd.Flags().Changed("aws-role-arn") {
+		config.RoleARN = awsRoleARN
+	}
+
+	return config
+}
+
+// mfaToken asks for the current code of the MFA device needed to assume a
+// role. It can only be asked for in a terminal, so commands run by the
+// scheduler must use credentials that don't need one. The AWS SDK gives it no
+// context, so it listens for Ctrl-C itself.
+func mfaToken() (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.New("An MFA code is needed to assume the AWS role, but there is no terminal to ask for it in.")
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	fmt.Fprint(os.Stderr, "MFA code: ")
+	line, err := readLine(ctx, bufio.NewReader(os.Stdin))
+	if err != nil && len(line) == 0 {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// bitsOf is the bitset of a field matching values.
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+func TestParseCronField(t *testing.T) {
+	minute := cronFields[0]
+	hour := cronFields[1]
+
+	tests := []struct {
+		name  string
+		field string
+		f     cronField
+		want  uint64
+		err   bool
+	}{
+		{name: "single value", field: "5", f: minute, want: bitsOf(5)},
+		{name: "list", field: "1,15,30", f: minute, want: bitsOf(1, 15, 30)},
+		{name: "range", field: "9-12", f: hour, want: bitsOf(9, 10, 11, 12)},
+		{name: "wildcard step", field: "*/15", f: minute, want: bitsOf(0, 15, 30, 45)},
+		{name: "step from a value", field: "5/20", f: minute, want: bitsOf(5, 25, 45)},
+		{name: "range step", field: "0-30/10", f: minute, want: bitsOf(0, 10, 20, 30)},
+		{name: "range step not reaching the end", field: "8-18/4", f: hour, want: bitsOf(8, 12, 16)},
+		{name: "list of range steps", field: "0-10/5,50-59/5", f: minute, want: bitsOf(0, 5, 10, 50, 55)},
+		{name: "out of range", field: "60", f: minute, err: true},
+		{name: "reversed range", field: "12-9", f: hour, err: true},
+		{name: "zero step", field: "*/0", f: minute, err: true},
+		{name: "bad step", field: "0-30/x", f: minute, err: true},
+		{name: "not a number", field: "noon", f: hour, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCronField(tt.field, tt.f)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("parseCronField(%q) = %b, want an error", tt.field, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseCronField(%q) error = %v", tt.field, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// Monday 6 January 2020.
+	monday := time.Date(2020, 1, 6, 18, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expression string
+		t          time.Time
+		want       bool
+	}{
+		{"30 18 * * 1-5", monday, true},
+		{"30 18 * * 1-5", monday.AddDate(0, 0, 5), false},
+		{"30 18 * * 0", monday.AddDate(0, 0, 6), true},
+		{"30 18 * * 7", monday.AddDate(0, 0, 6), true},
+		{"*/15 * * * *", monday.Add(15 * time.Minute), true},
+		{"*/15 * * * *", monday.Add(time.Minute), false},
+		// Either restricted day field matching is enough.
+		{"30 18 1 * 1", monday, true},
+		{"30 18 6 * 0", monday, true},
+		{"30 18 1 * 0", monday, false},
+		// A stepped wildcard doesn't restrict its field, so both must match.
+		{"30 18 */2 * 1-5", monday, false},
+		{"30 18 */2 * 1-5", monday.AddDate(0, 0, 1), true},
+		{"30 18 */2 * 1-5", monday.AddDate(0, 0, 5), false},
+		{"30 18 1 * */2", monday.AddDate(0, 0, 1), false},
+	}
+
+	for _, tt := range tests {
+		c, err := parseCron(tt.expression)
+		if err != nil {
+			t.Fatalf("parseCron(%q) error = %v", tt.expression, err)
+		}
+
+		if got := c.Matches(tt.t); got != tt.want {
+			t.Errorf("%q.Matches(%s) = %t, want %t", tt.expression, tt.t.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "* * * * * *", "* * 0 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expression)
+		}
+	}
+}
+
+func TestCronNextAndLast(t *testing.T) {
+	c, err := parseCron("0 23 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Friday 10 January 2020.
+	friday := time.Date(2020, 1, 10, 22, 0, 0, 0, time.UTC)
+
+	next, ok := c.Next(friday)
+	if want := time.Date(2020, 1, 10, 23, 0, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Errorf("Next() = %s, %t, want %s", next, ok, want)
+	}
+
+	next, ok = c.Next(friday.Add(time.Hour))
+	if want := time.Date(2020, 1, 13, 23, 0, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Errorf("Next() after it fires = %s, %t, want %s", next, ok, want)
+	}
+
+	last, ok := c.Last(friday.AddDate(0, 0, -2), friday.AddDate(0, 0, 1))
+	if want := time.Date(2020, 1, 10, 23, 0, 0, 0, time.UTC); !ok || !last.Equal(want) {
+		t.Errorf("Last() = %s, %t, want %s", last, ok, want)
+	}
+
+	if last, ok := c.Last(friday.Add(time.Hour), friday.AddDate(0, 0, 2)); ok {
+		t.Errorf("Last() over a weekend = %s, want nothing", last)
+	}
+
+	// after is excluded and until is included.
+	at := time.Date(2020, 1, 10, 23, 0, 0, 0, time.UTC)
+	if _, ok := c.Last(at, at.Add(time.Minute)); ok {
+		t.Error("Last() included after")
+	}
+	if _, ok := c.Last(at.Add(-time.Minute), at); !ok {
+		t.Error("Last() excluded until")
+	}
+}
+
+func TestDueActions(t *testing.T) {
+	s := Schedule{Name: "weeknight", Start: "30 18 * * 1-5", Stop: "0 23 * * 1-5"}
+
+	// Monday 6 January 2020.
+	day := func(hour, minute int) time.Time { return time.Date(2020, 1, 6, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name        string
+		after, tick time.Time
+		want        []string
+	}{
+		{name: "nothing due", after: day(12, 0), tick: day(12, 1)},
+		{name: "start on time", after: day(18, 29), tick: day(18, 30), want: []string{ScheduleActionStart}},
+		{name: "missed start", after: day(18, 0), tick: day(19, 0), want: []string{ScheduleActionStart}},
+		{name: "missed start and stop", after: day(18, 0), tick: day(23, 30), want: []string{ScheduleActionStop}},
+		{name: "missed stop", after: day(22, 0), tick: day(23, 30), want: []string{ScheduleActionStop}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, due := range dueActions(s, tt.after, tt.tick) {
+				got = append(got, due.action)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("dueActions() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("dueActions() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	t.Run("back to back", func(t *testing.T) {
+		handover := Schedule{Start: "0 20 * * *", Stop: "0 20 * * *"}
+		due := dueActions(handover, day(19, 59), day(20, 0))
+		if len(due) != 2 || due[0].action != ScheduleActionStop || due[1].action != ScheduleActionStart {
+			t.Fatalf("dueActions() = %v, want a stop then a start", due)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+)
+
+// Exit Codes
+const (
+	ExitFailure     = 1
+	ExitUnavailable = 3
+	ExitTerraform   = 4
+	ExitBusy        = 75
+	ExitTimeout     = 124
+	ExitInterrupted = 130
+)
+
+// exitCode maps an error returned by a cobra RunE function to the exit code
+// the command should end with.
+func exitCode(err error) int {
+	var (
+		noDefaultVPC *parsec.ErrNoDefaultVPC
+		noSubnet     *parsec.ErrNoSubnetInAZ
+		unavailable  *parsec.ErrInstanceTypeUnavailable
+		terraform    *parsec.ErrTerraform
+		busy         *parsec.ErrSessionBusy
+	)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.As(err, &noDefaultVPC), errors.As(err, &noSubnet), errors.As(err, &unavailable):
+		return ExitUnavailable
+	case errors.As(err, &terraform):
+		return ExitTerraform
+	case errors.As(err, &busy):
+		return ExitBusy
+	default:
+		return ExitFailure
+	}
+}
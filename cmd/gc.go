@@ -0,0 +1,203 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// gcCmd represents the gc command
+var gcCmd = &cobra.Command{
+	Use:     "gc",
+	Aliases: []string{"doctor"},
+	Short:   "Find and terminate AWS resources left behind by lost sessions",
+	Long: `
+Scans every AWS region for resources created by parsec-ec2 that do not belong
+to the current session: security groups, spot requests, instances, EBS
+volumes and the VPCs, subnets, route tables and internet gateways created by
+--create-vpc tagged with a parsec-ec2 session, along with the security groups
+named "parsec", spot requests and instances tagged Name=ParsecServer and
+volumes attached to them that were created by older versions.
+
+This is useful when $HOME/.parsec-ec2/currentSession.json has been lost and
+'parsec-ec2 stop' can no longer clean up after a session.
+
+The resources found are listed along with an estimate of what they cost, and
+you will be asked before anything is terminated unless the --yes flag is used.
+Only resources tagged with your user (the user config key, or your login name)
+are terminated. Those of other users, and those from older versions that
+aren't tagged with a user, are listed but never terminated.
+
+Examples:
+
+parsec-ec2 gc
+parsec-ec2 doctor --yes
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := unlockIfForced(); err != nil {
+			return err
+		}
+
+		ctx, cancel := commandContext()
+		defer cancel()
+
+		user := viper.GetString(CfgUser)
+		if len(user) == 0 {
+			user = parsec.CurrentUser()
+		}
+
+		fmt.Println("Scanning all regions for resources created by parsec-ec2...")
+		results, errs, err := client.FindOrphans(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+
+		var owned, others []parsec.Orphans
+		for _, o := range results {
+			if o.Owned {
+				owned = append(owned, o)
+			} else {
+				others = append(others, o)
+			}
+		}
+
+		if len(others) > 0 {
+			fmt.Println("\nThese resources belong to other users, or to no user, and will not be terminated:")
+			for _, o := range others {
+				printOrphans(o)
+			}
+		}
+
+		if len(owned) == 0 {
+			fmt.Printf("\nNo orphaned resources belonging to %s found.\n", user)
+			return nil
+		}
+
+		if len(others) > 0 {
+			fmt.Printf("\nThese resources belong to %s:\n", user)
+		}
+
+		var hourly, monthly float64
+		for _, o := range owned {
+			printOrphans(o)
+			hourly += o.HourlyCost
+			monthly += o.MonthlyCost
+		}
+
+		fmt.Printf("\nThese resources are costing roughly $%.2f/hour for instances and $%.2f/month for volumes.\n", hourly, monthly)
+
+		if !gcYes && !confirm(ctx, "Terminate all of them?") {
+			fmt.Println("Nothing has been terminated.")
+			return nil
+		}
+
+		var failed []string
+		for _, o := range owned {
+			fmt.Printf("Terminating resources in %s... ", o.Region)
+			if err := client.CleanupOrphans(ctx, o); err != nil {
+				fmt.Println(err)
+				failed = append(failed, o.Region)
+				continue
+			}
+			fmt.Println("Complete.")
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("Could not terminate every resource in %s.", strings.Join(failed, ", "))
+		}
+
+		return nil
+	},
+}
+
+func printOrphans(o parsec.Orphans) {
+	switch {
+	case o.Owned:
+		fmt.Printf("\n%s:\n", o.Region)
+	case len(o.User) == 0:
+		fmt.Printf("\n%s, not tagged with a user:\n", o.Region)
+	default:
+		fmt.Printf("\n%s, belonging to %s:\n", o.Region, o.User)
+	}
+
+	for _, group := range o.SecurityGroups {
+		fmt.Printf("  security group  %s in %s\n", aws.StringValue(group.GroupId), aws.StringValue(group.VpcId))
+	}
+
+	for _, request := range o.SpotRequests {
+		fmt.Printf("  spot request    %s (%s, max $%s/hour)\n",
+			aws.StringValue(request.SpotInstanceRequestId), aws.StringValue(request.State), aws.StringValue(request.SpotPrice))
+	}
+
+	for _, instance := range o.Instances {
+		fmt.Printf("  instance        %s (%s, %s since %s)\n",
+			aws.StringValue(instance.InstanceId), aws.StringValue(instance.InstanceType),
+			aws.StringValue(instance.State.Name), aws.TimeValue(instance.LaunchTime).Local().Format("Jan 2 15:04"))
+	}
+
+	for _, volume := range o.Volumes {
+		fmt.Printf("  volume          %s (%d GB %s, %s)\n",
+			aws.StringValue(volume.VolumeId), aws.Int64Value(volume.Size), aws.StringValue(volume.VolumeType), aws.StringValue(volume.State))
+	}
+
+	for _, vpc := range o.Vpcs {
+		fmt.Printf("  vpc             %s (%s)\n", aws.StringValue(vpc.VpcId), aws.StringValue(vpc.CidrBlock))
+	}
+
+	for _, subnet := range o.Subnets {
+		fmt.Printf("  subnet          %s in %s\n", aws.StringValue(subnet.SubnetId), aws.StringValue(subnet.VpcId))
+	}
+
+	for _, table := range o.RouteTables {
+		fmt.Printf("  route table     %s in %s\n", aws.StringValue(table.RouteTableId), aws.StringValue(table.VpcId))
+	}
+
+	for _, gateway := range o.InternetGateways {
+		fmt.Printf("  gateway         %s\n", aws.StringValue(gateway.InternetGatewayId))
+	}
+}
+
+func confirm(ctx context.Context, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, err := readLine(ctx, bufio.NewReader(os.Stdin))
+	if err != nil {
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+var gcYes bool
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+	addForceUnlockFlag(gcCmd)
+	gcCmd.Flags().BoolVarP(&gcYes, "yes", "y", false, "terminate the resources found without asking")
+}
@@ -16,7 +16,6 @@ package cmd
 
 import (
 	"fmt"
-
 	"os"
 
 	"github.com/spf13/cobra"
@@ -30,44 +29,29 @@ var initCmd = &cobra.Command{
 Copies the latest Terraform and Windows provisioning templates to the
 $HOME/.parsec-ec2 directory and runs 'terraform init' to initialise any
 plugins required by Terraform.
+
+If a backend is configured under 'backend' in $HOME/.parsec-ec2.yaml, the
+Terraform configuration for it is written and any existing state is copied
+to it. Run init again on every machine after changing the backend.
 `,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := commandContext()
+		defer cancel()
+
 		// Check if the install directory exists
 		fmt.Println("Checking for existing installation...")
 		if _, err := os.Stat(installPath); os.IsNotExist(err) {
 			fmt.Print("No existing installation found. Copying templates and initialising... ")
-			// If it doesn't exist, make it
-			err := os.Mkdir(installPath, 0755)
-			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
 		} else {
 			fmt.Print("Existing installation found. Copying latest templates... ")
 		}
 
-		tSrc := fmt.Sprintf("%s/%s", projectPath, Template)
-		tDst := fmt.Sprintf("%s/%s", installPath, Template)
-		if err := copy(tSrc, tDst); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		uSrc := fmt.Sprintf("%s/%s", projectPath, Userdata)
-		uDst := fmt.Sprintf("%s/%s", installPath, Userdata)
-		if err := copy(uSrc, uDst); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		init := tfCmd([]string{TfCmdInit})
-
-		if err := executeSilent(init); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if err := client.Init(ctx, projectPath); err != nil {
+			return err
 		}
 
 		fmt.Println("Complete.")
+		return nil
 	},
 }
 
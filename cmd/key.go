@@ -0,0 +1,108 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// keyCmd represents the key command
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Store the Parsec server key in the OS keyring",
+	Long: `
+Manages the Parsec server key in the OS keyring (the Keychain on macOS, the
+Secret Service on Linux and the Credential Manager on Windows), which is where
+the start command reads it from by default.
+
+Examples:
+
+parsec-ec2 key set
+parsec-ec2 key delete
+`,
+}
+
+var keySetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Store the Parsec server key in the OS keyring",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, err := readSecret("Parsec server key: ")
+		if err != nil {
+			return err
+		}
+
+		if len(key) == 0 {
+			return parsec.ErrNoServerKey
+		}
+
+		if err := serverKeyring().Set(key); err != nil {
+			return err
+		}
+
+		fmt.Println("The server key has been stored in the keyring.")
+		return nil
+	},
+}
+
+var keyDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Remove the Parsec server key from the OS keyring",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := serverKeyring().Delete(); err != nil {
+			return err
+		}
+
+		fmt.Println("The server key has been removed from the keyring.")
+		return nil
+	},
+}
+
+func serverKeyring() parsec.KeyringSecret {
+	return parsec.KeyringSecret{Service: parsec.KeyringService, User: ServerKeySecret}
+}
+
+// readSecret prompts for a secret without echoing it, or reads a line from
+// stdin when it isn't a terminal so that the key can be piped in.
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	fmt.Print(prompt)
+	b, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func init() {
+	RootCmd.AddCommand(keyCmd)
+	keyCmd.AddCommand(keySetCmd, keyDeleteCmd)
+}
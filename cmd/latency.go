@@ -0,0 +1,127 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	latencyRefresh bool
+	latencyHTTPS   bool
+)
+
+// latencyCmd represents the latency command
+var latencyCmd = &cobra.Command{
+	Use:   "latency",
+	Short: "Measure the latency to every AWS region",
+	Long: `
+Measures the round trip time to the EC2 endpoint of every AWS region at the
+same time, and lists the regions from nearest to furthest.
+
+By default the time taken to open a TCP connection is measured. Use --https
+(or set latency_method to https in the config file) to time HTTPS requests
+instead, which also works through an HTTP proxy. Each region is measured
+latency_probes times, 3 by default, keeping the quickest.
+
+Results are saved in $HOME/.parsec-ec2/latency.json and reused for
+latency_cache_age, 6h by default, by this command, by 'price --rank', by
+'start --best-region' and by 'start --interactive'. Use --refresh to measure
+again, for example after moving to another network.
+
+Examples:
+
+parsec-ec2 latency
+parsec-ec2 latency --https --refresh
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := commandContext()
+		defer cancel()
+
+		if latencyHTTPS {
+			viper.Set(CfgLatencyMethod, parsec.LatencyMethodHTTPS)
+		}
+
+		latencies, measured, err := regionLatencies(ctx, latencyRefresh)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "REGION\tLATENCY")
+		for _, l := range latencies {
+			if l.Err != nil {
+				fmt.Fprintf(w, "%s\tunreachable\n", l.Region)
+			} else {
+				fmt.Fprintf(w, "%s\t%d ms\n", l.Region, l.Latency.Milliseconds())
+			}
+		}
+		w.Flush()
+
+		fmt.Printf("\nMeasured with %s %s ago.\n", viper.GetString(CfgLatencyMethod), time.Since(measured).Round(time.Minute))
+		return nil
+	},
+}
+
+// latencyOptions are the latency settings from the config file.
+func latencyOptions() parsec.LatencyOptions {
+	return parsec.LatencyOptions{
+		Method: viper.GetString(CfgLatencyMethod),
+		Probes: viper.GetInt(CfgLatencyProbes),
+	}
+}
+
+// regionLatencies returns the cached latencies, measuring them if needed.
+func regionLatencies(ctx context.Context, refresh bool) ([]parsec.RegionLatency, time.Time, error) {
+	return client.Latency(ctx, latencyOptions(), viper.GetDuration(CfgLatencyCacheAge), refresh)
+}
+
+// rankRegions ranks the regions for an instance type by latency and price,
+// printing any regions whose price couldn't be looked up.
+func rankRegions(ctx context.Context, instanceType string) ([]parsec.RegionScore, error) {
+	latencies, _, err := regionLatencies(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	scores, warnings, err := client.RankRegions(ctx, instanceType, latencies, viper.GetFloat64(CfgLatencyWeight))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, warning := range warnings {
+		fmt.Printf("Skipping %s\n", warning)
+	}
+
+	if len(scores) == 0 {
+		return nil, fmt.Errorf("%s instances are not available in any reachable region.", instanceType)
+	}
+
+	return scores, nil
+}
+
+func init() {
+	RootCmd.AddCommand(latencyCmd)
+	latencyCmd.Flags().BoolVar(&latencyRefresh, "refresh", false, "measure again instead of using the saved results")
+	latencyCmd.Flags().BoolVar(&latencyHTTPS, "https", false, "time HTTPS requests instead of TCP connections")
+}
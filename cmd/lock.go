@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// forceUnlock is shared by every command that takes the session lock.
+var forceUnlock bool
+
+func addForceUnlockFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&forceUnlock, "force-unlock", false, "remove the session lock left by another parsec-ec2 command before running")
+}
+
+// unlockIfForced removes the session lock when --force-unlock is given,
+// saying whose lock it was.
+func unlockIfForced() error {
+	if !forceUnlock {
+		return nil
+	}
+
+	lock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+
+	if lock == nil {
+		return nil
+	}
+
+	if lock.PID > 0 {
+		fmt.Printf("Removing the lock taken for %s by process %d on %s at %s.\n", lock.Operation, lock.PID, lock.Host, lock.Created.Local().Format(time.Kitchen))
+	} else {
+		fmt.Println("Removing the session lock.")
+	}
+
+	return client.ForceUnlock()
+}
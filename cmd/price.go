@@ -16,12 +16,15 @@ package cmd
 
 import (
 	"fmt"
-
 	"os"
+	"text/tabwriter"
 
+	"github.com/jpmontez/parsec-ec2/parsec"
 	"github.com/spf13/cobra"
 )
 
+var priceRank bool
+
 // priceCmd represents the price command
 var priceCmd = &cobra.Command{
 	Use:   "price",
@@ -30,39 +33,52 @@ var priceCmd = &cobra.Command{
 Looks for the current highest spot price for the requested instance type
 in the requested region.
 
-Example:
+With --rank, the price is looked up in every region instead, and the regions
+are ranked by a score combining their latency (see 'parsec-ec2 latency') and
+price. Each is scaled from 0 for the best region to 1 for the worst, and the
+latency_weight config key, 0.5 by default, sets how much latency counts
+against price: 1 ranks by latency alone and 0 by price alone.
+
+Examples:
 
 parsec-ec2 price --region eu-west-1 --instance-type g2.2xlarge
+parsec-ec2 price --instance-type g2.2xlarge --rank
 `,
-	Run: func(cmd *cobra.Command, args []string) {
-		if !isValidRegion(ec2Regions(), region) {
-			fmt.Printf("%s is not a valid AWS region id.\n", region)
-			os.Exit(1)
-		}
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := commandContext()
+		defer cancel()
 
-		if !isValidGInstance(gInstances(), instanceType) {
-			fmt.Printf("%s is not a valid EC2 GPU instance type id.\n", instanceType)
-			os.Exit(1)
-		}
+		if priceRank {
+			scores, err := rankRegions(ctx, instanceType)
+			if err != nil {
+				return err
+			}
 
-		ec2Client, err := getEc2Client(region)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "RANK\tREGION\tLATENCY\tPRICE\tSCORE")
+			for i, s := range scores {
+				fmt.Fprintf(w, "%d\t%s\t%d ms\t$%.4f/hour\t%.2f\n", i+1, s.Region, s.Latency.Milliseconds(), s.Price, s.Score)
+			}
+			w.Flush()
+			return nil
 		}
 
-		spotPrice, err := getSpotPrice(ec2Client, instanceType)
+		spotPrice, err := client.Price(ctx, parsec.PriceOptions{
+			Region:       region,
+			InstanceType: instanceType,
+		})
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
 
 		dollarPrice := *spotPrice.SpotPrice
 
 		fmt.Printf("The highest spot price in the %s region for %s instances is currently $%s/hour.\n", region, instanceType, dollarPrice)
+		return nil
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(priceCmd)
+	priceCmd.Flags().BoolVar(&priceRank, "rank", false, "rank every region by latency and price instead of looking up one region")
 }
@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/viper"
+)
+
+// Config Value Kinds
+const (
+	configString   = "string"
+	configFloat    = "number"
+	configInt      = "whole number"
+	configBool     = "true or false"
+	configDuration = "duration"
+	configList     = "list"
+	configMap      = "map"
+)
+
+// configKeys are the settings parsec-ec2 reads from the config file, and the
+// kind of value each one takes. Every setting except profile can also be put
+// in a profile.
+var configKeys = map[string]string{
+	CfgProfile:               configString,
+	CfgRegion:                configString,
+	CfgInstanceType:          configString,
+	CfgBid:                   configFloat,
+	CfgRootVolumeSize:        configInt,
+	CfgDataVolumeSize:        configInt,
+	CfgDataVolumeType:        configString,
+	CfgDataVolumeIOPS:        configInt,
+	CfgDataVolumeThroughput:  configInt,
+	CfgEncryptVolumes:        configBool,
+	CfgKMSKeyID:              configString,
+	CfgLatencyMethod:         configString,
+	CfgLatencyProbes:         configInt,
+	CfgLatencyCacheAge:       configDuration,
+	CfgLatencyWeight:         configFloat,
+	CfgIPProviders:           configList,
+	CfgIPTimeout:             configDuration,
+	CfgSTUNServers:           configList,
+	CfgServerKey:             configString,
+	CfgServerKeySource:       configString,
+	CfgUser:                  configString,
+	CfgMonthlyBudget:         configFloat,
+	CfgBudgetAction:          configString,
+	CfgBudgetSessionHours:    configFloat,
+	CfgMaxSessionDuration:    configDuration,
+	CfgIdleTimeout:           configDuration,
+	CfgTags:                  configMap,
+	CfgVpcID:                 configString,
+	CfgCreateVpc:             configBool,
+	CfgAWSProfile:            configString,
+	CfgAWSRoleARN:            configString,
+	CfgAWSExternalID:         configString,
+	CfgAWSMFASerial:          configString,
+	CfgAWSSessionDuration:    configDuration,
+	CfgParsecStartPort:       configInt,
+	CfgParsecBitrate:         configInt,
+	CfgParsecResolution:      configString,
+	CfgParsecHostName:        configString,
+	CfgBackendType:           configString,
+	CfgBackendBucket:         configString,
+	CfgBackendKey:            configString,
+	CfgBackendRegion:         configString,
+	CfgBackendDynamoDBTable:  configString,
+	CfgBackendAddress:        configString,
+	CfgBackendSessionAddress: configString,
+	CfgBackendLockAddress:    configString,
+	CfgBackendUnlockAddress:  configString,
+	CfgBackendPath:           configString,
+}
+
+// configSections are the settings that group others, such as backend.type.
+var configSections = []string{"aws", "parsec", "backend"}
+
+// activeProfile is the profile whose settings have been merged into the
+// config, and profileSettings are those settings.
+var (
+	activeProfile   string
+	profileSettings map[string]interface{}
+)
+
+// applyProfile merges the selected profile over the settings at the top of
+// the config file. Settings from the environment and from flags still take
+// precedence over the profile. The profile is chosen with --profile,
+// PARSEC_EC2_PROFILE or the profile setting, in that order.
+func applyProfile() error {
+	name := profile
+	if len(name) == 0 {
+		name = viper.GetString(CfgProfile)
+	}
+
+	if len(name) == 0 {
+		return nil
+	}
+
+	activeProfile = name
+
+	settings, ok := profiles()[name]
+	if !ok {
+		return fmt.Errorf("There is no profile named %s in the config file. Create it with 'parsec-ec2 config set --profile %s <key> <value>'.", name, name)
+	}
+
+	profileSettings = settings
+	return viper.MergeConfigMap(settings)
+}
+
+// profiles returns the settings of every profile in the config file.
+func profiles() map[string]map[string]interface{} {
+	all := map[string]map[string]interface{}{}
+
+	for name := range viper.GetStringMap(CfgProfiles) {
+		all[name] = viper.GetStringMap(fmt.Sprintf("%s.%s", CfgProfiles, name))
+	}
+
+	return all
+}
+
+func profileNames() []string {
+	var names []string
+	for name := range profiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// inProfile reports whether the active profile sets a key, which may name a
+// setting in a section such as backend.type.
+func inProfile(key string) bool {
+	settings := profileSettings
+	path := strings.Split(key, ".")
+
+	for i, part := range path {
+		value, ok := settings[part]
+		if !ok {
+			return false
+		}
+
+		if i == len(path)-1 {
+			return true
+		}
+
+		if settings, ok = value.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+
+	return false
+}
+
+// envName is the environment variable that overrides a setting.
+func envName(key string) string {
+	return fmt.Sprintf("PARSEC_EC2_%s", strings.ToUpper(strings.Replace(key, ".", "_", -1)))
+}
+
+// configSource says where the value of a setting comes from.
+func configSource(key string) string {
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		return fmt.Sprintf("env %s", envName(key))
+	}
+
+	if inProfile(key) {
+		return fmt.Sprintf("profile %s", activeProfile)
+	}
+
+	if viper.InConfig(key) {
+		return "config"
+	}
+
+	return "default"
+}
+
+// configPrecedence ranks where the value of a setting comes from, higher
+// numbers taking precedence, for settings that override one another.
+func configPrecedence(key string) int {
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		return 3
+	}
+
+	if inProfile(key) {
+		return 2
+	}
+
+	if viper.InConfig(key) {
+		return 1
+	}
+
+	return 0
+}
+
+// checkConfigValue checks a value given on the command line for a setting,
+// returning an error that says what is wrong with it.
+func checkConfigValue(key, value string) error {
+	kind, ok := configKeys[key]
+	if !ok {
+		return fmt.Errorf("%s is not a parsec-ec2 setting.", key)
+	}
+
+	switch kind {
+	case configFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("%s must be a number that is zero or more, not %q.", key, value)
+		}
+	case configInt:
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 {
+			return fmt.Errorf("%s must be a whole number that is zero or more, not %q.", key, value)
+		}
+	case configBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false, not %q.", key, value)
+		}
+	case configDuration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("%s must be a duration such as 30m or 4h, not %q.", key, value)
+		}
+	case configMap:
+		for _, pair := range splitList(value) {
+			if !strings.Contains(pair, "=") {
+				return fmt.Errorf("%s must be given as key=value pairs separated by commas, not %q.", key, value)
+			}
+		}
+	}
+
+	switch key {
+	case CfgRegion:
+		if !parsec.IsValidRegion(parsec.Regions(), value) {
+			return &parsec.ErrInvalidRegion{Region: value}
+		}
+	case CfgInstanceType:
+		if !parsec.IsValidGInstance(parsec.GInstances(), value) {
+			return &parsec.ErrInvalidInstanceType{InstanceType: value}
+		}
+	case CfgBudgetAction:
+		if value != parsec.BudgetActionWarn && value != parsec.BudgetActionRefuse {
+			return fmt.Errorf("%s must be either %s or %s, not %q.", key, parsec.BudgetActionWarn, parsec.BudgetActionRefuse, value)
+		}
+	case CfgServerKeySource:
+		if _, err := parsec.NewSecretSource(value, ServerKeySecret); err != nil {
+			return err
+		}
+	case CfgDataVolumeType:
+		if err := (parsec.Volumes{DataVolumeType: value}).Validate(); err != nil {
+			return err
+		}
+	case CfgKMSKeyID:
+		if err := (parsec.Volumes{KMSKeyID: value}).Validate(); err != nil {
+			return err
+		}
+	case CfgParsecStartPort:
+		port, _ := strconv.Atoi(value)
+		if err := (parsec.HostSettings{StartPort: port}).Validate(); err != nil {
+			return err
+		}
+	case CfgParsecBitrate:
+		bitrate, _ := strconv.Atoi(value)
+		if err := (parsec.HostSettings{Bitrate: bitrate}).Validate(); err != nil {
+			return err
+		}
+	case CfgParsecResolution:
+		if err := (parsec.HostSettings{Resolution: value}).Validate(); err != nil {
+			return err
+		}
+	case CfgParsecHostName:
+		if err := (parsec.HostSettings{HostName: value}).Validate(); err != nil {
+			return err
+		}
+	case CfgLatencyMethod:
+		if value != parsec.LatencyMethodTCP && value != parsec.LatencyMethodHTTPS {
+			return fmt.Errorf("%s must be either %s or %s, not %q.", key, parsec.LatencyMethodTCP, parsec.LatencyMethodHTTPS, value)
+		}
+	case CfgLatencyWeight:
+		if f, _ := strconv.ParseFloat(value, 64); f > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, not %q.", key, value)
+		}
+	case CfgBackendType:
+		if value != parsec.BackendLocal && value != parsec.BackendS3 && value != parsec.BackendHTTP {
+			return fmt.Errorf("%s must be one of %s, %s or %s, not %q.", key, parsec.BackendLocal, parsec.BackendS3, parsec.BackendHTTP, value)
+		}
+	}
+
+	return nil
+}
+
+// checkSettings checks the settings in the config file or in a profile,
+// which are nested as they are in the file, and returns every problem found.
+func checkSettings(prefix string, settings map[string]interface{}) []error {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		value := settings[key]
+		name := key
+		if len(prefix) > 0 {
+			name = fmt.Sprintf("%s.%s", prefix, key)
+		}
+
+		if section, ok := value.(map[string]interface{}); ok && isConfigSection(name) {
+			errs = append(errs, checkSettings(name, section)...)
+			continue
+		}
+
+		switch configKeys[name] {
+		case configList:
+			if _, ok := value.([]interface{}); !ok {
+				errs = append(errs, fmt.Errorf("%s must be a list.", name))
+			}
+		case configMap:
+			if _, ok := value.(map[string]interface{}); !ok {
+				errs = append(errs, fmt.Errorf("%s must be a map.", name))
+			}
+		default:
+			if err := checkConfigValue(name, fmt.Sprint(value)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+func isConfigSection(name string) bool {
+	for _, section := range configSections {
+		if name == section {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitList splits a comma separated value given on the command line.
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+// formatConfigValue formats the value of a setting for display.
+func formatConfigValue(key string) string {
+	switch configKeys[key] {
+	case configList:
+		return strings.Join(viper.GetStringSlice(key), ", ")
+	case configMap:
+		m := viper.GetStringMapString(key)
+
+		var pairs []string
+		for k, v := range m {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+		}
+		sort.Strings(pairs)
+
+		return strings.Join(pairs, ", ")
+	}
+
+	return viper.GetString(key)
+}
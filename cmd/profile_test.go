@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// useConfig loads a config file and selects a profile the way initConfig
+// does, undoing it when the test ends.
+func useConfig(t *testing.T, config, name string) {
+	t.Helper()
+
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	viper.SetEnvPrefix("parsec_ec2")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	if err := viper.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, activeProfile, profileSettings = name, "", nil
+	t.Cleanup(func() {
+		viper.Reset()
+		profile, activeProfile, profileSettings = "", "", nil
+	})
+
+	if err := applyProfile(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConfigValue(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		err   bool
+	}{
+		{key: CfgRegion, value: "eu-west-1"},
+		{key: CfgRegion, value: "mars-north-1", err: true},
+		{key: CfgInstanceType, value: "g2.2xlarge"},
+		{key: CfgInstanceType, value: "t2.micro", err: true},
+		{key: CfgBid, value: "0.10"},
+		{key: CfgBid, value: "-1", err: true},
+		{key: CfgBid, value: "cheap", err: true},
+		{key: CfgRootVolumeSize, value: "60"},
+		{key: CfgRootVolumeSize, value: "1.5", err: true},
+		{key: CfgEncryptVolumes, value: "true"},
+		{key: CfgEncryptVolumes, value: "yes please", err: true},
+		{key: CfgIdleTimeout, value: "30m"},
+		{key: CfgIdleTimeout, value: "30", err: true},
+		{key: CfgTags, value: "owner=jade,team=games"},
+		{key: CfgTags, value: "owner", err: true},
+		{key: CfgBudgetAction, value: parsec.BudgetActionRefuse},
+		{key: CfgBudgetAction, value: "panic", err: true},
+		{key: CfgServerKeySource, value: "pass:parsec/server_key"},
+		{key: CfgServerKeySource, value: "pass:", err: true},
+		{key: CfgServerKeySource, value: "vault:parsec", err: true},
+		{key: CfgDataVolumeType, value: "io1"},
+		{key: CfgDataVolumeType, value: "gp3", err: true},
+		{key: CfgDataVolumeType, value: "floppy", err: true},
+		{key: CfgParsecStartPort, value: "9000"},
+		{key: CfgParsecStartPort, value: "80", err: true},
+		{key: CfgParsecBitrate, value: "0"},
+		{key: CfgParsecResolution, value: "1920x1080"},
+		{key: CfgParsecResolution, value: "1080p", err: true},
+		{key: CfgLatencyMethod, value: parsec.LatencyMethodHTTPS},
+		{key: CfgLatencyMethod, value: "ping", err: true},
+		{key: CfgLatencyWeight, value: "0.5"},
+		{key: CfgLatencyWeight, value: "1.5", err: true},
+		{key: CfgBackendType, value: parsec.BackendS3},
+		{key: CfgBackendType, value: "ftp", err: true},
+		{key: "colour", value: "blue", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s=%s", tt.key, tt.value), func(t *testing.T) {
+			err := checkConfigValue(tt.key, tt.value)
+			if tt.err && err == nil {
+				t.Errorf("checkConfigValue(%s, %q) succeeded, want an error", tt.key, tt.value)
+			}
+			if !tt.err && err != nil {
+				t.Errorf("checkConfigValue(%s, %q) error = %v", tt.key, tt.value, err)
+			}
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "valid",
+			config: `
+region: eu-west-1
+profile: weeknight
+tags:
+  owner: jade
+stun_servers: [stun.example.com:3478]
+backend:
+  type: s3
+profiles:
+  weeknight:
+    instance_type: g2.2xlarge
+    parsec:
+      bitrate: 20
+`,
+		},
+		{
+			name: "bad values",
+			config: `
+region: mars-north-1
+bid: cheap
+stun_servers: stun.example.com
+backend:
+  type: ftp
+`,
+			want: []string{"backend.type", "bid", "mars-north-1", "stun_servers must be a list"},
+		},
+		{
+			name: "bad profiles",
+			config: `
+profile: weekend
+profiles:
+  weeknight:
+    profile: weeknight
+    idle_timeout: soon
+  broken: true
+`,
+			want: []string{"no profile with that name", "Profile broken must be a map", "Profile weeknight: idle_timeout", "Profile weeknight: the profile setting"},
+		},
+		{
+			name:   "profiles not a map",
+			config: "profiles: [weeknight]\n",
+			want:   []string{"profiles must be a map"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := map[string]interface{}{}
+			if err := yaml.Unmarshal([]byte(tt.config), &settings); err != nil {
+				t.Fatal(err)
+			}
+
+			errs := checkConfig(settings)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("checkConfig() = %v, want %d problem(s)", errs, len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("checkConfig()[%d] = %q, want it to mention %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestServerKeySecretSource(t *testing.T) {
+	pass := parsec.PassSecret{Name: "parsec/server_key"}
+	keyring := parsec.KeyringSecret{Service: parsec.KeyringService, User: ServerKeySecret}
+
+	tests := []struct {
+		name    string
+		config  string
+		profile string
+		env     map[string]string
+		flags   map[string]string
+		want    parsec.SecretSource
+	}{
+		{
+			name: "default",
+			want: keyring,
+		},
+		{
+			name:   "key flag over everything",
+			config: "server_key_source: command:false\n",
+			env:    map[string]string{"PARSEC_EC2_SERVER_KEY": "from-env"},
+			flags:  map[string]string{"server-key": "from-flag"},
+			want:   parsec.StaticSecret("from-flag"),
+		},
+		{
+			name:  "source flag over a key in the environment",
+			env:   map[string]string{"PARSEC_EC2_SERVER_KEY": "from-env"},
+			flags: map[string]string{"server-key-source": "pass:parsec/server_key"},
+			want:  pass,
+		},
+		{
+			name:   "key in the environment over a source in the config",
+			config: "server_key_source: pass:parsec/server_key\n",
+			env:    map[string]string{"PARSEC_EC2_SERVER_KEY": "from-env"},
+			want:   parsec.StaticSecret("from-env"),
+		},
+		{
+			name:   "source in the environment over a key in the config",
+			config: "server_key: from-config\n",
+			env:    map[string]string{"PARSEC_EC2_SERVER_KEY_SOURCE": "pass:parsec/server_key"},
+			want:   pass,
+		},
+		{
+			name:    "source in the profile over a key in the config",
+			config:  "server_key: from-config\nprofiles:\n  weeknight:\n    server_key_source: pass:parsec/server_key\n",
+			profile: "weeknight",
+			want:    pass,
+		},
+		{
+			name:   "key wins a tie",
+			config: "server_key: from-config\nserver_key_source: pass:parsec/server_key\n",
+			want:   parsec.StaticSecret("from-config"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			useConfig(t, tt.config, tt.profile)
+
+			serverKey, serverKeySource = "", ""
+			defer func() { serverKey, serverKeySource = "", "" }()
+
+			cmd := &cobra.Command{}
+			cmd.Flags().StringVar(&serverKey, "server-key", "", "")
+			cmd.Flags().StringVar(&serverKeySource, "server-key-source", "", "")
+			for name, value := range tt.flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := serverKeySecretSource(cmd)
+			if err != nil {
+				t.Fatalf("serverKeySecretSource() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serverKeySecretSource() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
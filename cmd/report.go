@@ -0,0 +1,148 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show monthly spend from the session ledger",
+	Long: `
+Summarises the final bills recorded in $HOME/.parsec-ec2/ledger.jsonl by
+month, with breakdowns per user, region and instance type.
+
+If a monthly_budget is set in the config file, the current month's spend
+for the current user is shown against it.
+
+Examples:
+
+parsec-ec2 report
+parsec-ec2 report --month 2017-11
+parsec-ec2 report --user jade
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(reportMonth) > 0 {
+			if _, err := time.Parse(ReportMonthFormat, reportMonth); err != nil {
+				return fmt.Errorf("%s is not a valid month, use the format YYYY-MM.", reportMonth)
+			}
+		}
+
+		entries, err := client.Ledger()
+		if err != nil {
+			return err
+		}
+
+		months := map[string][]parsec.LedgerEntry{}
+		for _, entry := range entries {
+			if entry.Event != parsec.LedgerEventStop {
+				continue
+			}
+
+			if len(reportUser) > 0 && entry.User != reportUser {
+				continue
+			}
+
+			month := entry.StopTime.UTC().Format(ReportMonthFormat)
+			if len(reportMonth) > 0 && month != reportMonth {
+				continue
+			}
+
+			months[month] = append(months[month], entry)
+		}
+
+		if len(months) == 0 {
+			fmt.Println("No completed sessions found in the ledger.")
+		}
+
+		var keys []string
+		for month := range months {
+			keys = append(keys, month)
+		}
+		sort.Strings(keys)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, month := range keys {
+			printMonthReport(w, month, months[month])
+		}
+		w.Flush()
+
+		if budget := viper.GetFloat64(CfgMonthlyBudget); budget > 0 {
+			user := viper.GetString(CfgUser)
+			if len(user) == 0 {
+				user = parsec.CurrentUser()
+			}
+			spent := parsec.MonthlySpend(entries, user, time.Now())
+			fmt.Printf("%s has spent $%.2f of a $%.2f budget this month.\n", user, spent, budget)
+		}
+
+		return nil
+	},
+}
+
+func printMonthReport(w *tabwriter.Writer, month string, entries []parsec.LedgerEntry) {
+	var total float64
+	var duration time.Duration
+
+	byUser := map[string]float64{}
+	byRegion := map[string]float64{}
+	byInstanceType := map[string]float64{}
+
+	for _, entry := range entries {
+		total += entry.TotalCost
+		duration += entry.StopTime.Sub(entry.LaunchTime)
+
+		byUser[entry.User] += entry.TotalCost
+		byRegion[entry.Region] += entry.TotalCost
+		byInstanceType[entry.InstanceType] += entry.TotalCost
+	}
+
+	fmt.Fprintf(w, "%s\t$%.2f\t%d sessions, %s\n", month, total, len(entries), duration.Round(time.Minute))
+	printBreakdown(w, "By user:", byUser)
+	printBreakdown(w, "By region:", byRegion)
+	printBreakdown(w, "By instance type:", byInstanceType)
+	fmt.Fprintln(w)
+}
+
+func printBreakdown(w *tabwriter.Writer, heading string, costs map[string]float64) {
+	var keys []string
+	for key := range costs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "  %s\t\t\n", heading)
+	for _, key := range keys {
+		fmt.Fprintf(w, "    %s\t$%.2f\t\n", key, costs[key])
+	}
+}
+
+var reportMonth, reportUser string
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().StringVarP(&reportMonth, "month", "m", "", "only report on the given month (YYYY-MM)")
+	reportCmd.Flags().StringVarP(&reportUser, "user", "u", "", "only report on sessions started by the given user")
+}
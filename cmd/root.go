@@ -15,9 +15,16 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/jpmontez/parsec-ec2/parsec"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -25,58 +32,192 @@ import (
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
-	Use:   "parsec-ec2",
-	Short: "Start and stop Parsec EC2 instances with a single command",
-	Long:  ``,
+	Use:           "parsec-ec2",
+	Short:         "Start and stop Parsec EC2 instances with a single command",
+	Long:          ``,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if configErr != nil {
+			return configErr
+		}
+
+		if profileErr != nil {
+			return profileErr
+		}
+
+		client.AWS = awsConfig(cmd)
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// Commands return their errors rather than exiting, so that deferred cancels
+// and unlocks run, and the error is mapped to an exit code here.
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
 var installPath, region, cfgFile, goPath, instanceType, projectPath string
 
+// client is shared by every command and manages the sessions in installPath.
+var client *parsec.Client
+
+var timeout time.Duration
+var verbose bool
+var profile string
+
+// configErr is set when the config file can't be read, which stops every
+// command before it runs.
+var configErr error
+
+// profileErr is set when the selected profile doesn't exist, which is only an
+// error for commands other than config.
+var profileErr error
+
 func init() {
 	cobra.OnInitialize(initConfig)
 
 	RootCmd.PersistentFlags().StringVarP(&region, "region", "r", "", "aws region")
 	RootCmd.PersistentFlags().StringVarP(&instanceType, "instance-type", "i", "", "ec2 instance type")
+	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.parsec-ec2.yaml)")
+	RootCmd.PersistentFlags().StringVar(&awsProfile, "aws-profile", "", "AWS shared config profile to take credentials from")
+	RootCmd.PersistentFlags().StringVar(&awsRoleARN, "aws-role-arn", "", "AWS role to assume for every AWS call and Terraform run")
+	RootCmd.PersistentFlags().StringVar(&profile, "profile", "", "use the settings in this profile from the config file")
+	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "show the output of Terraform as it runs")
+	// Usage is only worth printing for a mistyped command line, not for
+	// every error a command returns.
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		cmd.Println(cmd.UsageString())
+		return err
+	})
+
+	RootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "give up on AWS and Terraform calls after this long, e.g. 10m (0 for no limit)")
+}
+
+// commandContext is cancelled by Ctrl-C or SIGTERM, and once --timeout has
+// passed if it is set. Cancelling it stops AWS calls and interrupts Terraform,
+// which is given time to finish what it is doing and save its state.
+func commandContext() (context.Context, context.CancelFunc) {
+	ctx, stop := interruptContext()
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// interruptContext is cancelled by Ctrl-C or SIGTERM. It is used while asking
+// questions, which --timeout shouldn't cut short.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// readLine reads a line from in, giving up when ctx is cancelled. Once the
+// signal handlers are installed Ctrl-C no longer interrupts a read from the
+// terminal, so the read is left running and abandoned.
+func readLine(ctx context.Context, in *bufio.Reader) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+
+	read := make(chan result, 1)
+	go func() {
+		line, err := in.ReadString('\n')
+		read <- result{line, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		fmt.Println()
+		return "", ctx.Err()
+	case r := <-read:
+		return r.line, r.err
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	// Find home directory.
+	home, err := homedir.Dir()
+	if err != nil {
+		configErr = err
+		return
+	}
+
+	goPath = os.Getenv("GOPATH")
+
+	projectPath = fmt.Sprintf("%s/src/github.com/lgug2z/parsec-ec2", goPath)
+	installPath = fmt.Sprintf("%s/.parsec-ec2", home)
+
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
 	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		goPath = os.Getenv("GOPATH")
-
-		projectPath = fmt.Sprintf("%s/src/github.com/lgug2z/parsec-ec2", goPath)
-		installPath = fmt.Sprintf("%s/.parsec-ec2", home)
-
 		// Search config in home directory with name ".parsec-ec2" (without extension).
 		viper.AddConfigPath(home)
 		viper.SetConfigName(".parsec-ec2")
 	}
 
+	client = parsec.NewClient(installPath)
+	client.Stdout = os.Stdout
+	client.Stderr = os.Stderr
+	client.Verbose = verbose
+
 	viper.SetEnvPrefix("parsec_ec2")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
-	serverKey = viper.GetString("server_key")
+	viper.SetDefault(CfgIPProviders, parsec.DefaultIPProviders())
+	viper.SetDefault(CfgSTUNServers, parsec.DefaultSTUNServers())
+	viper.SetDefault(CfgIPTimeout, parsec.DefaultIPTimeout)
+	viper.SetDefault(CfgBudgetAction, parsec.BudgetActionWarn)
+	viper.SetDefault(CfgBudgetSessionHours, parsec.DefaultBudgetSessionHours)
+	viper.SetDefault(CfgLatencyMethod, parsec.LatencyMethodTCP)
+	viper.SetDefault(CfgLatencyProbes, parsec.DefaultLatencyProbes)
+	viper.SetDefault(CfgLatencyCacheAge, parsec.DefaultLatencyCacheAge)
+	viper.SetDefault(CfgLatencyWeight, parsec.DefaultLatencyWeight)
+	viper.SetDefault(CfgParsecStartPort, parsec.DefaultParsecStartPort)
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. A file given with --config that
+	// doesn't exist yet can be created with 'config set'.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	} else if os.IsNotExist(err) {
+		fmt.Printf("Config file %s does not exist, using the defaults.\n", cfgFile)
+	} else if _, notFound := err.(viper.ConfigFileNotFoundError); !notFound {
+		configErr = fmt.Errorf("Could not read the config file: %s", err)
+		return
+	}
+
+	profileErr = applyProfile()
+
+	if len(region) == 0 {
+		region = viper.GetString(CfgRegion)
+	}
+
+	if len(instanceType) == 0 {
+		instanceType = viper.GetString(CfgInstanceType)
+	}
+
+	client.Backend = parsec.Backend{
+		Type:           viper.GetString(CfgBackendType),
+		Bucket:         viper.GetString(CfgBackendBucket),
+		Key:            viper.GetString(CfgBackendKey),
+		Region:         viper.GetString(CfgBackendRegion),
+		DynamoDBTable:  viper.GetString(CfgBackendDynamoDBTable),
+		Address:        viper.GetString(CfgBackendAddress),
+		SessionAddress: viper.GetString(CfgBackendSessionAddress),
+		LockAddress:    viper.GetString(CfgBackendLockAddress),
+		UnlockAddress:  viper.GetString(CfgBackendUnlockAddress),
+		Path:           viper.GetString(CfgBackendPath),
 	}
 }
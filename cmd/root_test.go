@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	line, err := readLine(context.Background(), bufio.NewReader(strings.NewReader("eu-west-1\nignored\n")))
+	if err != nil || line != "eu-west-1\n" {
+		t.Errorf("readLine() = %q, %v, want the first line", line, err)
+	}
+
+	// Nothing is ever typed, so only cancelling can end the read.
+	r, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := readLine(ctx, bufio.NewReader(r))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("readLine() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readLine() kept waiting for input after it was cancelled")
+	}
+}
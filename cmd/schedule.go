@@ -0,0 +1,449 @@
+// Copyright © 2017 Jade Iqbal <jadeiqbal@fastmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Schedule starts and stops a session at times given as cron expressions.
+type Schedule struct {
+	Name         string  `json:"name"`
+	Start        string  `json:"start"`
+	Stop         string  `json:"stop"`
+	Region       string  `json:"region"`
+	InstanceType string  `json:"instance_type"`
+	Bid          float64 `json:"bid"`
+
+	// Profile is passed to the scheduled start, so that the rest of its
+	// settings apply to the session too.
+	Profile string `json:"profile,omitempty"`
+}
+
+// Schedule Actions
+const (
+	ScheduleActionStart = "start"
+	ScheduleActionStop  = "stop"
+)
+
+// HistoryEntry records the outcome of a scheduled start or stop.
+type HistoryEntry struct {
+	Time     time.Time `json:"time"`
+	Schedule string    `json:"schedule"`
+	Action   string    `json:"action"`
+	Success  bool      `json:"success"`
+	Output   string    `json:"output"`
+
+	// Due is when a missed action was due, if it was caught up late.
+	Due time.Time `json:"due,omitempty"`
+}
+
+// scheduleCmd represents the schedule command
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Start and stop Parsec EC2 instances at fixed times",
+	Long: `
+Manages schedules which start and stop sessions at times given in cron syntax
+(minute hour day-of-month month day-of-week). Schedules are stored in
+$HOME/.parsec-ec2/schedule.json and are carried out by 'parsec-ec2 schedule run',
+which should be left running in the background.
+
+Examples:
+
+parsec-ec2 schedule add weeknight --start "30 18 * * 1-5" --stop "0 23 * * 1-5" --region eu-west-1 --instance-type g2.2xlarge --bid 0.10
+parsec-ec2 schedule add weeknight --start "30 18 * * 1-5" --stop "0 23 * * 1-5" --profile weeknight
+parsec-ec2 schedule list
+parsec-ec2 schedule remove weeknight
+nohup parsec-ec2 schedule run &
+`,
+}
+
+var scheduleAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Add or replace a schedule",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !cmd.Flags().Changed("bid") {
+			bid = viper.GetFloat64(CfgBid)
+		}
+
+		if !parsec.IsValidRegion(parsec.Regions(), region) {
+			return &parsec.ErrInvalidRegion{Region: region}
+		}
+
+		if !parsec.IsValidGInstance(parsec.GInstances(), instanceType) {
+			return &parsec.ErrInvalidInstanceType{InstanceType: instanceType}
+		}
+
+		if _, err := parseCron(scheduleStart); err != nil {
+			return fmt.Errorf("Invalid start schedule: %s", err)
+		}
+
+		if _, err := parseCron(scheduleStop); err != nil {
+			return fmt.Errorf("Invalid stop schedule: %s", err)
+		}
+
+		schedules, err := readSchedules()
+		if err != nil {
+			return err
+		}
+
+		s := Schedule{
+			Name:         args[0],
+			Start:        scheduleStart,
+			Stop:         scheduleStop,
+			Region:       region,
+			InstanceType: instanceType,
+			Bid:          bid,
+			Profile:      activeProfile,
+		}
+
+		replaced := false
+		for i := range schedules {
+			if schedules[i].Name == s.Name {
+				schedules[i] = s
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			schedules = append(schedules, s)
+		}
+
+		if err := writeSchedules(schedules); err != nil {
+			return err
+		}
+
+		fmt.Printf("Schedule %s saved. Make sure 'parsec-ec2 schedule run' is running for it to take effect.\n", s.Name)
+		return nil
+	},
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List schedules and when they next run",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schedules, err := readSchedules()
+		if err != nil {
+			return err
+		}
+
+		if len(schedules) == 0 {
+			fmt.Println("There are no schedules.")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTART\tSTOP\tREGION\tINSTANCE TYPE\tBID\tNEXT START\tNEXT STOP")
+		for _, s := range schedules {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.2f\t%s\t%s\n",
+				s.Name, s.Start, s.Stop, s.Region, s.InstanceType, s.Bid, nextRun(s.Start), nextRun(s.Stop))
+		}
+		w.Flush()
+		return nil
+	},
+}
+
+var scheduleRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a schedule",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schedules, err := readSchedules()
+		if err != nil {
+			return err
+		}
+
+		var kept []Schedule
+		for _, s := range schedules {
+			if s.Name != args[0] {
+				kept = append(kept, s)
+			}
+		}
+
+		if len(kept) == len(schedules) {
+			return fmt.Errorf("There is no schedule called %s.", args[0])
+		}
+
+		if err := writeSchedules(kept); err != nil {
+			return err
+		}
+
+		fmt.Printf("Schedule %s removed.\n", args[0])
+		return nil
+	},
+}
+
+var scheduleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the scheduler in the foreground",
+	Long: `
+Checks the schedules at the start of every minute and runs 'parsec-ec2 start'
+or 'parsec-ec2 stop' when one is due. The schedule file is re-read every
+minute, so schedules can be added and removed while the scheduler is running.
+
+A scheduled start is skipped if a session is already running, and a scheduled
+stop is skipped if there is no session. The outcome of every scheduled action
+is appended to $HOME/.parsec-ec2/history.jsonl.
+
+If the machine was asleep when actions were due, the scheduler catches up when
+it wakes by running the last action each schedule missed in the past 24 hours,
+so a missed start is skipped if its stop was missed too. Actions due while the
+scheduler wasn't running are not caught up.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		// --timeout applies to each scheduled start and stop rather than to
+		// the scheduler itself, which runs until it is interrupted.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		fmt.Println("Scheduler started.")
+
+		last := time.Now().Truncate(time.Minute)
+		for {
+			now := time.Now()
+			select {
+			case <-ctx.Done():
+				fmt.Println("Scheduler stopped.")
+				return nil
+			case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+			}
+			tick := time.Now().Truncate(time.Minute)
+
+			// Timers don't run while the machine is asleep, so every minute
+			// since the last tick is checked. If the clock went back, only
+			// this minute is.
+			after := last
+			if !tick.After(after) {
+				after = tick.Add(-time.Minute)
+			}
+			if tick.Sub(after) > ScheduleCatchUp {
+				after = tick.Add(-ScheduleCatchUp)
+			}
+			last = tick
+
+			schedules, err := readSchedules()
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			for _, s := range schedules {
+				for _, due := range dueActions(s, after, tick) {
+					runScheduled(ctx, executable, s, due.action, due.time, tick)
+				}
+			}
+		}
+	},
+}
+
+type dueAction struct {
+	action string
+	time   time.Time
+}
+
+// dueActions are the actions a schedule has to run for the minutes after
+// after, up to and including tick. Only the last of them is run, as running
+// a missed start after its stop was missed too would leave a session running,
+// unless the start and stop are due in the same minute, when the stop runs
+// first so that back to back schedules hand over cleanly.
+func dueActions(s Schedule, after, tick time.Time) []dueAction {
+	var stopAt, startAt time.Time
+	var stopDue, startDue bool
+
+	if stop, err := parseCron(s.Stop); err == nil {
+		stopAt, stopDue = stop.Last(after, tick)
+	}
+
+	if start, err := parseCron(s.Start); err == nil {
+		startAt, startDue = start.Last(after, tick)
+	}
+
+	switch {
+	case stopDue && startDue && stopAt.Equal(startAt):
+		return []dueAction{{ScheduleActionStop, stopAt}, {ScheduleActionStart, startAt}}
+	case stopDue && (!startDue || stopAt.After(startAt)):
+		return []dueAction{{ScheduleActionStop, stopAt}}
+	case startDue:
+		return []dueAction{{ScheduleActionStart, startAt}}
+	}
+
+	return nil
+}
+
+// runScheduled invokes this executable's start or stop command for a schedule
+// and records the outcome in the history file. If the scheduler is stopped
+// while the command is running, the command is interrupted so that it can
+// stop Terraform cleanly. An action that was due before tick was missed, and
+// is recorded as such.
+func runScheduled(ctx context.Context, executable string, s Schedule, action string, due, tick time.Time) {
+	entry := HistoryEntry{Time: time.Now().UTC(), Schedule: s.Name, Action: action}
+	if due.Before(tick) {
+		entry.Due = due.UTC()
+		fmt.Printf("%s %s %s: catching up with the %s missed at %s.\n", entry.Time.Local().Format(time.RFC3339), s.Name, action, action, due.Format("Mon Jan 2 15:04"))
+	}
+
+	_, err := client.Session(ctx)
+	hasSession := err == nil
+
+	var args []string
+	switch action {
+	case ScheduleActionStart:
+		args = []string{"start", "--region", s.Region, "--instance-type", s.InstanceType, "--bid", fmt.Sprint(s.Bid)}
+		if len(s.Profile) > 0 {
+			args = append(args, "--profile", s.Profile)
+		}
+		if hasSession {
+			entry.Output = "Skipped because a session is already running."
+		}
+	case ScheduleActionStop:
+		args = []string{"stop"}
+		if !hasSession {
+			entry.Output = "Skipped because there is no session running."
+		}
+	}
+
+	if timeout > 0 {
+		args = append(args, "--timeout", timeout.String())
+	}
+
+	// The scheduler's own settings apply to the commands it runs.
+	if len(cfgFile) > 0 {
+		args = append(args, "--config", cfgFile)
+	}
+
+	if len(awsProfile) > 0 {
+		args = append(args, "--aws-profile", awsProfile)
+	}
+
+	if len(awsRoleARN) > 0 {
+		args = append(args, "--aws-role-arn", awsRoleARN)
+	}
+
+	if len(entry.Output) == 0 {
+		command := exec.CommandContext(ctx, executable, args...)
+		command.Cancel = func() error { return command.Process.Signal(os.Interrupt) }
+		command.WaitDelay = parsec.DefaultInterruptGrace
+
+		output, err := command.CombinedOutput()
+		entry.Success = err == nil
+		entry.Output = strings.TrimSpace(string(output))
+		if err != nil {
+			entry.Output = fmt.Sprintf("%s\n%s", entry.Output, err)
+		}
+	}
+
+	fmt.Printf("%s %s %s: %s\n", entry.Time.Local().Format(time.RFC3339), s.Name, entry.Action, entry.Output)
+
+	if err := appendHistory(entry); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// appendHistory appends an entry to the history file as a single line of JSON.
+func appendHistory(entry HistoryEntry) error {
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	filePath := fmt.Sprintf("%s/%s", installPath, History)
+
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(bytes, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func nextRun(expression string) string {
+	c, err := parseCron(expression)
+	if err != nil {
+		return "invalid"
+	}
+
+	next, ok := c.Next(time.Now())
+	if !ok {
+		return "never"
+	}
+
+	return next.Format("Mon Jan 2 15:04")
+}
+
+func readSchedules() ([]Schedule, error) {
+	filePath := fmt.Sprintf("%s/%s", installPath, Schedules)
+
+	bytes, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []Schedule
+	if err := json.Unmarshal(bytes, &schedules); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func writeSchedules(schedules []Schedule) error {
+	bytes, err := json.MarshalIndent(schedules, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filePath := fmt.Sprintf("%s/%s", installPath, Schedules)
+
+	return ioutil.WriteFile(filePath, bytes, 0644)
+}
+
+var scheduleStart, scheduleStop string
+
+func init() {
+	RootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.AddCommand(scheduleAddCmd, scheduleListCmd, scheduleRemoveCmd, scheduleRunCmd)
+
+	scheduleAddCmd.Flags().StringVar(&scheduleStart, "start", "", "cron expression for when to start the session")
+	scheduleAddCmd.Flags().StringVar(&scheduleStop, "stop", "", "cron expression for when to stop the session")
+	scheduleAddCmd.Flags().Float64VarP(&bid, "bid", "b", 0.00, "amount to bid relative to the current highest spot price")
+}
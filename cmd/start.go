@@ -15,13 +15,16 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
-	"os"
-	"os/exec"
-	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jpmontez/parsec-ec2/parsec"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // startCmd represents the start command
@@ -30,101 +33,381 @@ var startCmd = &cobra.Command{
 	Short: "Start a new Parsec EC2 instance",
 	Long: `
 Makes a spot request for the requested EC2 instance type in the specified region.
-If PARSEC_EC2_SERVER_KEY has not been exported in the shell rc file, it must be
-passed to the command using the --server-key flag.
+
+The Parsec server key is read from the OS keyring, where it can be stored with
+'parsec-ec2 key set'. It can be read from somewhere else by setting the
+server_key_source config key (or the --server-key-source flag) to pass:<entry>
+or command:<command>, or given directly with --server-key or by exporting
+PARSEC_EC2_SERVER_KEY. The key is never written to disk by parsec-ec2.
 
 The amount to bid relative to the current highest spot price for an instance is
 specified using the --bid flag, so if the current highest spot price is $0.20,
 running the command with --bid 0.10 will send a spot request with a bid price
 of $0.30.
 
+The security group only allows traffic from the public IPv4 address of the
+machine running this command. The address is looked up using the services in
+the ip_providers config key, falling back to the STUN servers in stun_servers,
+and can be set explicitly with the --ip flag.
+
+If a monthly_budget is set in the config file, the spot request will warn or
+be refused (depending on budget_action) when a session at the bid price would
+take this month's spend over budget.
+
+A forgotten session keeps costing money until it is stopped. The --max-duration
+and --idle-timeout flags (or the max_session_duration and idle_timeout config
+keys) provision a watchdog on the instance which shuts it down, terminating
+the spot instance, once the session has run for the given time or no Parsec
+client has been connected for the given time. Run 'parsec-ec2 stop' afterwards
+to clean up the remaining resources.
+
+Every resource created is tagged with the session name (set with --name, up
+to 64 letters, digits, dots, dashes and underscores), the user, the creation
+time and the parsec-ec2 version, along with any extra tags from the tags
+config key.
+
+Instances are launched into the region's default VPC unless another VPC is
+given with --vpc-id. If the default VPC has been deleted, --create-vpc has
+Terraform create a minimal VPC for the session, with a public subnet in each
+availability zone, which is removed again by the stop command.
+
 If the --plan flag is used, the spot request will not be sent and instead the
 'terraform plan' command will be run which will output to the console the details
-of any AWS resources that will be created by running the start command.
+of any AWS resources that will be created by running the start command. The plan
+is saved, and 'parsec-ec2 apply <plan-id>' makes exactly the spot request that
+was reviewed.
+
+The instance gets a 50 GB root volume and a 100 GB gp2 data volume. Their
+sizes are set with --root-volume-size and --data-volume-size, and the data
+volume's type with --data-volume-type: gp2, io1 (with --data-volume-iops,
+3000 by default) or none to leave it out. gp3 and io2 volumes and
+--data-volume-throughput need a newer AWS provider than Terraform 0.10 can
+run, so they are refused.
+--encrypt-volumes encrypts both volumes with the account's default EBS key, or
+with --kms-key-id. Each flag has a config key of the same name with
+underscores, such as data_volume_type. The volumes are checked against EC2's
+limits before anything is created, and their cost is included in the cost
+shown by status and stop and in the budget check.
+
+The Parsec host's start port, encoder bitrate, resolution and host name are
+set with the parsec.start_port, parsec.bitrate, parsec.resolution and
+parsec.host_name config keys. The security group opens the start port and the
+40 ports after it.
+
+PowerShell snippets in $HOME/.parsec-ec2/provision.d, such as 10-steam.ps1,
+are run in order of their names once Parsec has been configured. They are Go
+templates rendered with the session's variables, such as {{ .Region }}, and
+can look up secrets with {{ secret "pass:<entry>" }}. The start command fails
+before anything is created if the user data they make up would be more than
+the 16 KB EC2 accepts.
+
+With --best-region, the instance is launched in the region ranked best for
+the instance type by 'parsec-ec2 price --rank'.
+
+With --interactive, the region (nearest first), instance type (with its GPU and
+current price), bid and data volume size are chosen from lists, and the choices
+can be saved as a config profile to be used again with --profile.
 
 Examples:
 
 parsec-ec2 start --aws-region eu-west-1 --instance-type g3.4xlarge --bid 0.10
-parsec-ec2 start --aws-region eu-west-1 --instance-type g2.2xlarge --bid 0.10 ---server-key xxxxx
+parsec-ec2 start --aws-region eu-west-1 --instance-type g2.2xlarge --bid 0.10 --server-key-source pass:parsec/server_key
 parsec-ec2 start --aws-region eu-central-1 --instance-type g2.2xlarge --bid 0.10 --plan
+parsec-ec2 start --interactive
 `,
-	Run: func(cmd *cobra.Command, args []string) {
-		// if !hasServerKey(serverKey) {
-		// 	fmt.Println("Add 'export PARSEC_EC2_SERVER_KEY=xxxxx' to your shell rc file or use the --server-key flag.")
-		// 	os.Exit(1)
-		// }
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keySource, err := serverKeySecretSource(cmd)
+		if err != nil {
+			return err
+		}
+
+		if !cmd.Flags().Changed("vpc-id") {
+			vpcID = viper.GetString(CfgVpcID)
+		}
+
+		if !cmd.Flags().Changed("create-vpc") {
+			createVpc = viper.GetBool(CfgCreateVpc)
+		}
+
+		if createVpc && len(vpcID) > 0 {
+			return errors.New("Use either --vpc-id or --create-vpc, not both.")
+		}
 
-		if !isValidRegion(ec2Regions(), region) {
-			fmt.Printf("%s is not a valid AWS region id.\n", region)
-			os.Exit(1)
+		if !cmd.Flags().Changed("bid") {
+			bid = viper.GetFloat64(CfgBid)
 		}
 
-		if !isValidGInstance(gInstances(), instanceType) {
-			fmt.Printf("%s is not a valid EC2 GPU instance type id.\n", instanceType)
-			os.Exit(1)
+		volumes := volumesFromFlags(cmd)
+
+		if err := unlockIfForced(); err != nil {
+			return err
+		}
+
+		if bestRegion && cmd.Flags().Changed("region") {
+			return errors.New("Use either --region or --best-region, not both.")
+		}
+
+		// The wizard is answered before the command's context is made, so
+		// that Ctrl-C can abort it and --timeout doesn't count the answering.
+		if interactive {
+			wizardCtx, stop := interruptContext()
+			start, err := useBestRegionAndRunWizard(wizardCtx, &volumes)
+			stop()
+			if err != nil {
+				return err
+			}
+
+			if !start {
+				return nil
+			}
 		}
 
-		ec2Client, err := getEc2Client(region)
+		ctx, cancel := commandContext()
+		defer cancel()
+
+		if bestRegion && !interactive {
+			if err := useBestRegion(ctx); err != nil {
+				return err
+			}
+		}
+
+		opts := parsec.StartOptions{
+			Region:       region,
+			InstanceType: instanceType,
+			Bid:          bid,
+			ServerKey:    keySource,
+			Session:      sessionName,
+			User:         viper.GetString(CfgUser),
+			Tags:         viper.GetStringMapString(CfgTags),
+			VpcID:        vpcID,
+			CreateVpc:    createVpc,
+			Volumes:      volumes,
+			Host:         hostSettings(),
+			IPResolver:   ipResolver(),
+			MaxDuration:  flagOrConfigDuration(cmd, "max-duration", maxDuration, CfgMaxSessionDuration),
+			IdleTimeout:  flagOrConfigDuration(cmd, "idle-timeout", idleTimeout, CfgIdleTimeout),
+			Budget: parsec.Budget{
+				Monthly:      viper.GetFloat64(CfgMonthlyBudget),
+				Action:       viper.GetString(CfgBudgetAction),
+				SessionHours: viper.GetFloat64(CfgBudgetSessionHours),
+			},
+		}
+
+		p, err := client.Prepare(ctx, opts)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
 
-		var p TfVars
+		check, err := client.CheckBudget(ctx, p, opts.Budget)
+		if err != nil {
+			return err
+		}
+
+		if check.Over {
+			if check.Running > 0 {
+				fmt.Printf("Your running session has cost $%.2f so far, which counts towards your budget.\n", check.Running)
+			}
+			fmt.Printf("You have spent $%.2f of your $%.2f monthly budget. A %.1f hour session at $%s/hour plus $%.4f/hour for storage would bring this month's spend to $%.2f.\n",
+				check.Spent, check.Budget, check.Hours, p.SpotPrice, p.Volumes.CostPerHour(p.Region), check.Projected)
 
-		if err := p.Calculate(ec2Client, region, serverKey, instanceType); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			if opts.Budget.Action == parsec.BudgetActionRefuse {
+				return fmt.Errorf("Refusing to start a session. Raise %s or set %s to %s in your config file to continue.",
+					CfgMonthlyBudget, CfgBudgetAction, parsec.BudgetActionWarn)
+			}
 		}
 
-		var start *exec.Cmd
+		fmt.Printf("Storage: %s, costing about $%.4f/hour.\n", p.Volumes, p.Volumes.CostPerHour(p.Region))
 
-		// TODO: Use a template to generate a .tfvars file
-		if plan {
-			start = tfCmdVars(p, []string{TfCmdPlan})
+		if len(p.ProvisionSnippets) > 0 {
+			fmt.Printf("Provisioning snippets: %s.\n", strings.Join(p.ProvisionSnippets, ", "))
+		}
 
+		if plan {
 			fmt.Printf("Planning spot request for a %s instance in %s with a bid of $%s...\n\n", p.InstanceType, p.Region, p.SpotPrice)
-			if err := executePrint(start); err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			saved, err := client.Plan(ctx, p)
+			if err != nil {
+				return err
 			}
 
-			fmt.Println("If you are happy with this plan run the start command again without the --plan flag.")
+			fmt.Printf("If you are happy with this plan run 'parsec-ec2 apply %s' within %s to make exactly this spot request.\n", saved.ID, parsec.PlanMaxAge)
 		} else {
-			start = tfCmdVars(p, []string{TfCmdApply})
 			fmt.Printf("Making spot request for a %s instance in %s with a bid of $%s...\n", p.InstanceType, p.Region, p.SpotPrice)
+			result, err := client.Apply(ctx, p)
+			if err != nil {
+				fmt.Println("The session has been saved so that 'parsec-ec2 stop' can terminate anything that was created.")
+				return err
+			}
 
-			if err := executeSilent(start); err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+			for _, warning := range result.Warnings {
+				fmt.Println(warning)
 			}
 
 			fmt.Println("Spot request made successfully. Check the status of the spot request with 'parsec-ec2 status'.")
-
-			if err := p.Write(); err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
 		}
+
+		return nil
 	},
 }
 
-func calculateUserBid(cheapestSpotPrice string, bidIncrease float64) string {
-	spotPrice, _ := strconv.ParseFloat(cheapestSpotPrice, 64)
-	userBid := spotPrice + bidIncrease
+var (
+	bid             float64
+	serverKey       string
+	serverKeySource string
+	plan            bool
+	ipOverride      string
+	sessionName     string
+	vpcID           string
+	createVpc       bool
+	interactive     bool
+	bestRegion      bool
 
-	return fmt.Sprint(userBid)
-}
+	rootVolumeSize       int
+	dataVolumeSize       int
+	dataVolumeType       string
+	dataVolumeIOPS       int
+	dataVolumeThroughput int
+	encryptVolumes       bool
+	kmsKeyID             string
 
-var (
-	bid       float64
-	serverKey string
-	plan      bool
+	maxDuration time.Duration
+	idleTimeout time.Duration
 )
 
+// ipResolver looks up the address to allow through the security group, using
+// the --ip flag if it is set and the configured providers otherwise.
+func ipResolver() parsec.IPResolver {
+	return parsec.NewIPResolver(
+		ipOverride,
+		viper.GetStringSlice(CfgIPProviders),
+		viper.GetStringSlice(CfgSTUNServers),
+		viper.GetDuration(CfgIPTimeout),
+	)
+}
+
+// volumesFromFlags returns the volumes given with flags, falling back to the
+// config file for those that weren't.
+func volumesFromFlags(cmd *cobra.Command) parsec.Volumes {
+	if !cmd.Flags().Changed("root-volume-size") {
+		rootVolumeSize = viper.GetInt(CfgRootVolumeSize)
+	}
+
+	if !cmd.Flags().Changed("data-volume-size") {
+		dataVolumeSize = viper.GetInt(CfgDataVolumeSize)
+	}
+
+	if !cmd.Flags().Changed("data-volume-type") {
+		dataVolumeType = viper.GetString(CfgDataVolumeType)
+	}
+
+	if !cmd.Flags().Changed("data-volume-iops") {
+		dataVolumeIOPS = viper.GetInt(CfgDataVolumeIOPS)
+	}
+
+	if !cmd.Flags().Changed("data-volume-throughput") {
+		dataVolumeThroughput = viper.GetInt(CfgDataVolumeThroughput)
+	}
+
+	if !cmd.Flags().Changed("encrypt-volumes") {
+		encryptVolumes = viper.GetBool(CfgEncryptVolumes)
+	}
+
+	if !cmd.Flags().Changed("kms-key-id") {
+		kmsKeyID = viper.GetString(CfgKMSKeyID)
+	}
+
+	return parsec.Volumes{
+		RootVolumeSize:       rootVolumeSize,
+		DataVolumeSize:       dataVolumeSize,
+		DataVolumeType:       dataVolumeType,
+		DataVolumeIOPS:       dataVolumeIOPS,
+		DataVolumeThroughput: dataVolumeThroughput,
+		EncryptVolumes:       encryptVolumes,
+		KMSKeyID:             kmsKeyID,
+	}
+}
+
+// hostSettings are the Parsec host settings from the config file.
+func hostSettings() parsec.HostSettings {
+	return parsec.HostSettings{
+		StartPort:  viper.GetInt(CfgParsecStartPort),
+		Bitrate:    viper.GetInt(CfgParsecBitrate),
+		Resolution: viper.GetString(CfgParsecResolution),
+		HostName:   viper.GetString(CfgParsecHostName),
+	}
+}
+
+// serverKeySecretSource is where the server key is read from. A key or source
+// given as a flag wins, then whichever of server_key and server_key_source is
+// set with the higher precedence, the key winning a tie, then the OS keyring.
+func serverKeySecretSource(cmd *cobra.Command) (parsec.SecretSource, error) {
+	if cmd.Flags().Changed("server-key") {
+		return parsec.StaticSecret(serverKey), nil
+	}
+
+	if cmd.Flags().Changed("server-key-source") {
+		return parsec.NewSecretSource(serverKeySource, ServerKeySecret)
+	}
+
+	key := viper.GetString(CfgServerKey)
+	source := viper.GetString(CfgServerKeySource)
+
+	if len(key) > 0 && (len(source) == 0 || configPrecedence(CfgServerKey) >= configPrecedence(CfgServerKeySource)) {
+		return parsec.StaticSecret(key), nil
+	}
+
+	if len(source) == 0 {
+		source = parsec.SecretSourceKeyring
+	}
+
+	return parsec.NewSecretSource(source, ServerKeySecret)
+}
+
+// useBestRegion sets the region to the best one for the instance type.
+func useBestRegion(ctx context.Context) error {
+	scores, err := rankRegions(ctx, instanceType)
+	if err != nil {
+		return err
+	}
+
+	best := scores[0]
+	region = best.Region
+	fmt.Printf("Using %s, the best region for %s instances at %d ms and $%.4f/hour.\n", best.Region, instanceType, best.Latency.Milliseconds(), best.Price)
+
+	return nil
+}
+
+// useBestRegionAndRunWizard runs the start wizard, with the best region as
+// its default if --best-region is used.
+func useBestRegionAndRunWizard(ctx context.Context, volumes *parsec.Volumes) (bool, error) {
+	if bestRegion {
+		if err := useBestRegion(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	return runStartWizard(ctx, volumes)
+}
+
 func init() {
 	RootCmd.AddCommand(startCmd)
+	addForceUnlockFlag(startCmd)
 	startCmd.Flags().Float64VarP(&bid, "bid", "b", 0.00, "amount to bid relative to the current highest spot price")
 	startCmd.Flags().StringVarP(&serverKey, "server-key", "k", "", "Parsec server key")
+	startCmd.Flags().StringVar(&serverKeySource, "server-key-source", "", "where to read the Parsec server key from: keyring, pass:<entry> or command:<command>")
 	startCmd.Flags().BoolVarP(&plan, "plan", "p", false, "plan out the resources to be created without creating them")
+	startCmd.Flags().DurationVar(&maxDuration, "max-duration", 0, "shut the instance down after this long, e.g. 4h (0 for no limit)")
+	startCmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 0, "shut the instance down after this long without a Parsec client connected, e.g. 30m (0 for no limit)")
+	startCmd.Flags().StringVarP(&sessionName, "name", "n", "", "name for the session, tagged on every resource it creates (defaults to <user>-<timestamp>)")
+	startCmd.Flags().StringVar(&vpcID, "vpc-id", "", "launch into this VPC instead of the region's default VPC")
+	startCmd.Flags().BoolVar(&createVpc, "create-vpc", false, "create a VPC for the session instead of using an existing one")
+	startCmd.Flags().BoolVar(&interactive, "interactive", false, "choose the region, instance type, bid and data volume size interactively")
+	startCmd.Flags().BoolVar(&bestRegion, "best-region", false, "launch in the region ranked best for the instance type by latency and price")
+	startCmd.Flags().IntVar(&rootVolumeSize, "root-volume-size", 0, fmt.Sprintf("size of the root volume in GB, at least %d (default %d)", parsec.MinRootVolumeSize, parsec.RootVolumeSize))
+	startCmd.Flags().IntVar(&dataVolumeSize, "data-volume-size", 0, fmt.Sprintf("size of the data volume in GB (default %d)", parsec.DataVolumeSize))
+	startCmd.Flags().StringVar(&dataVolumeType, "data-volume-type", "", "type of the data volume: gp2, io1 or none for no data volume (default gp2)")
+	startCmd.Flags().IntVar(&dataVolumeIOPS, "data-volume-iops", 0, "IOPS of an io1 data volume (default 3000)")
+	startCmd.Flags().IntVar(&dataVolumeThroughput, "data-volume-throughput", 0, "throughput of a gp3 data volume in MiB/s, which Terraform 0.10 can't create")
+	startCmd.Flags().BoolVar(&encryptVolumes, "encrypt-volumes", false, "encrypt the root and data volumes")
+	startCmd.Flags().StringVar(&kmsKeyID, "kms-key-id", "", "KMS key id, alias or ARN to encrypt the volumes with instead of the default EBS key")
+	startCmd.Flags().StringVar(&ipOverride, "ip", "", "public IPv4 address to allow through the security group instead of looking it up")
 }
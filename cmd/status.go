@@ -15,12 +15,13 @@
 package cmd
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"text/tabwriter"
+	"time"
 
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/jpmontez/parsec-ec2/parsec"
 	"github.com/spf13/cobra"
 )
 
@@ -36,78 +37,97 @@ some time for the instance to show up in the Parsec desktop application.
 This is because time is still required for the provisioning script to run
 on the instance, which is what will allow the Parsec application to launch
 and log in with the provided Parsec server key.
-`,
-	Run: func(cmd *cobra.Command, args []string) {
-		session := fmt.Sprintf("%s/%s", installPath, CurrentSession)
 
-		bytes, err := ioutil.ReadFile(session)
-		if err != nil {
-			fmt.Println("There are no sessions currently running.")
-			os.Exit(0)
+The provisioning script reports each of its steps, including every snippet
+from provision.d, to the instance's console, and status shows the last state
+of each one along with the error from any that failed. Newer (Nitro) instance
+types update their console output as it is written, while older ones only
+update it a few times as they boot, so progress can lag behind the instance.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := unlockIfForced(); err != nil {
+			return err
 		}
 
-		var p TfVars
+		ctx, cancel := commandContext()
+		defer cancel()
 
-		if err := json.Unmarshal(bytes, &p); err != nil {
+		status, err := client.Status(ctx)
+		if errors.Is(err, parsec.ErrNoSession) {
 			fmt.Println(err)
-			os.Exit(1)
+			return nil
 		}
-
-		ec2Client, err := getEc2Client(p.Region)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return err
 		}
 
-		refresh := tfCmdVars(p, []string{TfCmdRefresh})
-		if err := executeSilent(refresh); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		printRunningCost(status.Cost)
+		printWatchdog(status.Session)
 
-		var o TfOutputs
-		if err := o.Read(); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		for _, warning := range status.Warnings {
+			fmt.Println(warning)
 		}
 
-		if len(o.SpotInstanceID.Value) < 1 {
+		if !status.Fulfilled() {
 			fmt.Println("The spot instance request is awaiting fulfilment.")
-			os.Exit(0)
-		}
-
-		instanceIds := []*string{&o.SpotInstanceID.Value}
-
-		describeInstanceStatusInput := ec2.DescribeInstanceStatusInput{
-			InstanceIds: instanceIds,
-		}
-
-		describeInstanceStatusOutput, err := ec2Client.DescribeInstanceStatus(&describeInstanceStatusInput)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return nil
 		}
 
-		if len(describeInstanceStatusOutput.InstanceStatuses) < 1 {
-			if o.SpotBidStatus.Value == "instance-terminated-by-price" {
+		if len(status.InstanceStatus) == 0 {
+			if status.SpotBidStatus == "instance-terminated-by-price" {
 				fmt.Println("The spot price rose above your bid price and your instance was terminated. Run 'parsec-ec2 stop' to cleanup.")
-				os.Exit(0)
+				return nil
 			}
 			fmt.Println("The spot instance request has been filled but the instance initialisation status is not available yet.")
-			os.Exit(0)
-		}
-
-		instanceStatus := describeInstanceStatusOutput.InstanceStatuses[0].InstanceStatus.Status
-
-		if *instanceStatus == OK {
+		} else if status.Initialised() {
 			fmt.Println("The instance has been initialised.")
-			fmt.Println("It will be connectable once the provisioning script has finished running.")
 		} else {
 			fmt.Println("The instance is initialising.")
 		}
+
+		printProvision(status.Provision)
+		return nil
 	},
 }
 
+// printProvision shows the provisioning progress the instance has reported,
+// and whether any of the steps failed.
+func printProvision(progress *parsec.ProvisionProgress) {
+	if progress == nil {
+		fmt.Println("It will be connectable once the provisioning script has finished running, which hasn't reported any progress yet.")
+		return
+	}
+
+	fmt.Printf("\nProvisioning started at %s:\n", progress.Started.Local().Format(time.Kitchen))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, step := range progress.Steps {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", step.State, step.Name, step.Message)
+	}
+	w.Flush()
+	fmt.Println()
+
+	failed := progress.Failed()
+	switch {
+	case len(failed) > 0:
+		fmt.Printf("%d of the provisioning steps failed. The full log is in %s on the instance.\n", len(failed), ProvisionLog)
+	case progress.Finished:
+		fmt.Println("Provisioning has finished, and the instance should be connectable from the Parsec app.")
+	default:
+		fmt.Println("Provisioning is still running.")
+	}
+}
+
+func printRunningCost(cost *parsec.SessionCost) {
+	if cost == nil {
+		return
+	}
+
+	fmt.Printf("The session has been running for %s and has cost $%.2f so far (instance $%.2f, storage $%.2f).\n",
+		cost.Duration().Round(time.Minute), cost.Total(), cost.InstanceCost, cost.StorageCost)
+}
+
 func init() {
 	RootCmd.AddCommand(statusCmd)
+	addForceUnlockFlag(statusCmd)
 }
@@ -15,13 +15,12 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
-	"os"
-
-	"encoding/json"
-	"io/ioutil"
+	"time"
 
+	"github.com/jpmontez/parsec-ec2/parsec"
 	"github.com/spf13/cobra"
 )
 
@@ -37,48 +36,56 @@ that are identified for creation in the terraform template.
 This command depends on session information that is created by the start
 command and stored in $HOME/.parsec-ec2/currentSession.json, so if this
 has been manually modified or removed after running the start command,
-the stop command will not execute. In this situation it is still possible
-to manually run 'terraform destroy'. You will receive prompts for variable
-values, but these can all be left blank with the exception of the region
-variable, which can be set to the region the instances were started in.
+the stop command will not execute. In this situation use 'parsec-ec2 gc'
+to find and terminate the resources that were left behind.
+
+Once the resources have been terminated, a final bill for the session is
+printed and appended to $HOME/.parsec-ec2/ledger.jsonl.
 
 Example:
 
 parsec-ec2 stop
 `,
-	Run: func(cmd *cobra.Command, args []string) {
-		session := fmt.Sprintf("%s/%s", installPath, CurrentSession)
-
-		bytes, err := ioutil.ReadFile(session)
-		if err != nil {
-			fmt.Println("No session information found.")
-			os.Exit(1)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := unlockIfForced(); err != nil {
+			return err
 		}
 
-		var p TfVars
+		ctx, cancel := commandContext()
+		defer cancel()
 
-		if err := json.Unmarshal(bytes, &p); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if _, err := client.Session(ctx); errors.Is(err, parsec.ErrNoSession) {
+			return errors.New("No session information found. Run 'parsec-ec2 gc' to look for resources left behind by a lost session.")
 		}
 
-		destroy := tfCmdVars(p, []string{TfCmdDestroy, TfFlagForce})
-
 		fmt.Println("Terminating all AWS resources created by this session... ")
-		if err := executeSilent(destroy); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		result, err := client.Stop(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, warning := range result.Warnings {
+			fmt.Println(warning)
 		}
 
-		if err := os.Remove(session); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if result.Cost != nil {
+			fmt.Printf("Final bill for the %s session in %s:\n", result.Session.InstanceType, result.Session.Region)
+			printCost(*result.Cost)
 		}
 
 		fmt.Println("All resources have been successfully terminated.")
+		return nil
 	},
 }
 
+func printCost(c parsec.SessionCost) {
+	fmt.Printf("  Duration:  %s\n", c.Duration().Round(time.Second))
+	fmt.Printf("  Instance:  $%.2f\n", c.InstanceCost)
+	fmt.Printf("  Storage:   $%.2f\n", c.StorageCost)
+	fmt.Printf("  Total:     $%.2f\n", c.Total())
+}
+
 func init() {
 	RootCmd.AddCommand(stopCmd)
+	addForceUnlockFlag(stopCmd)
 }
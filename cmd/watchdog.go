@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// flagOrConfigDuration returns the value of a duration flag if it was set on
+// the command line, otherwise the value of the matching config key.
+func flagOrConfigDuration(cmd *cobra.Command, flag string, value time.Duration, key string) time.Duration {
+	if cmd.Flags().Changed(flag) {
+		return value
+	}
+	return viper.GetDuration(key)
+}
+
+func printWatchdog(p *parsec.Session) {
+	if deadline := p.WatchdogDeadline(); !deadline.IsZero() {
+		if remaining := time.Until(deadline); remaining > 0 {
+			fmt.Printf("The watchdog will shut the instance down at %s (in %s).\n",
+				deadline.Local().Format(time.Kitchen), remaining.Round(time.Minute))
+		} else {
+			fmt.Printf("The watchdog's deadline of %s has passed and the instance has shut down or is shutting down.\n",
+				deadline.Local().Format(time.Kitchen))
+		}
+	}
+
+	if p.IdleTimeoutMinutes > 0 {
+		fmt.Printf("The watchdog will shut the instance down after %d minutes without a Parsec client connected.\n", p.IdleTimeoutMinutes)
+	}
+}
@@ -0,0 +1,329 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jpmontez/parsec-ec2/parsec"
+	"github.com/spf13/viper"
+)
+
+// wizardBidMargins are the bid strategies offered by the start wizard, as
+// amounts added to the current spot price.
+var wizardBidMargins = []struct {
+	Margin      float64
+	Description string
+}{
+	{0, "the cheapest, but the instance is the first to go if the price rises"},
+	{0.05, "rides out small price rises"},
+	{0.20, "rarely interrupted, but can cost more when the price rises"},
+}
+
+// wizard asks the questions that the start command's flags would otherwise
+// answer. Cancelling ctx abandons the question being asked.
+type wizard struct {
+	ctx context.Context
+	in  *bufio.Reader
+}
+
+func newWizard(ctx context.Context) *wizard {
+	return &wizard{ctx: ctx, in: bufio.NewReader(os.Stdin)}
+}
+
+// ask asks a question, returning def if it is answered with an empty line.
+func (w *wizard) ask(question, def string) (string, error) {
+	if len(def) > 0 {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+
+	line, err := readLine(w.ctx, w.in)
+	if err != nil && len(line) == 0 {
+		return "", err
+	}
+
+	if line = strings.TrimSpace(line); len(line) == 0 {
+		return def, nil
+	}
+
+	return line, nil
+}
+
+// choose asks for one of a numbered list of options, returning its index.
+func (w *wizard) choose(question string, options []string, def int) (int, error) {
+	for i, option := range options {
+		fmt.Printf("  %2d) %s\n", i+1, option)
+	}
+
+	for {
+		answer, err := w.ask(question, strconv.Itoa(def+1))
+		if err != nil {
+			return 0, err
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+
+		fmt.Printf("Enter a number between 1 and %d.\n", len(options))
+	}
+}
+
+func (w *wizard) confirm(question string) (bool, error) {
+	answer, err := w.ask(fmt.Sprintf("%s (y/n)", question), "y")
+	if err != nil {
+		return false, err
+	}
+
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// wizardPrice is the current spot price of an instance type in a region.
+type wizardPrice struct {
+	InstanceType string
+	Spec         parsec.InstanceSpec
+	Price        float64
+}
+
+// runStartWizard asks for the region, instance type, bid and data volume size
+// of a session, offering to save them as a profile, and sets them for the
+// start command. It returns false if the session shouldn't be started. It is
+// run before the command's context is made, so that --timeout doesn't count
+// the time spent answering, and ctx should only be cancelled by Ctrl-C.
+func runStartWizard(ctx context.Context, volumes *parsec.Volumes) (bool, error) {
+	w := newWizard(ctx)
+
+	fmt.Println("Measuring the latency to each region...")
+	latencies, _, err := client.Latency(ctx, latencyOptions(), viper.GetDuration(CfgLatencyCacheAge), false)
+	if err != nil {
+		return false, err
+	}
+
+	var regionOptions []string
+	defaultRegion := 0
+	for i, l := range latencies {
+		if l.Err != nil {
+			regionOptions = append(regionOptions, fmt.Sprintf("%-16s unreachable", l.Region))
+		} else {
+			regionOptions = append(regionOptions, fmt.Sprintf("%-16s %4d ms", l.Region, l.Latency.Milliseconds()))
+		}
+
+		if l.Region == region {
+			defaultRegion = i
+		}
+	}
+
+	fmt.Println("\nWhich region should the instance be launched in? The nearest regions are listed first.")
+	choice, err := w.choose("Region", regionOptions, defaultRegion)
+	if err != nil {
+		return false, err
+	}
+	chosen := latencies[choice]
+
+	fmt.Printf("\nLooking up spot prices in %s...\n", chosen.Region)
+	prices, err := wizardPrices(ctx, chosen.Region)
+	if err != nil {
+		return false, err
+	}
+
+	if len(prices) == 0 {
+		return false, fmt.Errorf("None of the instance types Parsec supports are available in %s.", chosen.Region)
+	}
+
+	var typeOptions []string
+	defaultType := 0
+	for i, p := range prices {
+		typeOptions = append(typeOptions, fmt.Sprintf("%-13s %d x %-18s %3d vCPUs %4.0f GiB  $%.4f/hour",
+			p.InstanceType, p.Spec.GPUs, p.Spec.GPU, p.Spec.VCPUs, p.Spec.MemoryGiB, p.Price))
+
+		if p.InstanceType == instanceType {
+			defaultType = i
+		}
+	}
+
+	fmt.Println("\nWhich instance type? Prices are the current highest spot price in the region.")
+	choice, err = w.choose("Instance type", typeOptions, defaultType)
+	if err != nil {
+		return false, err
+	}
+	price := prices[choice]
+
+	var bidOptions []string
+	for _, b := range wizardBidMargins {
+		bidOptions = append(bidOptions, fmt.Sprintf("Bid $%.4f/hour (+$%.2f): %s", price.Price+b.Margin, b.Margin, b.Description))
+	}
+	bidOptions = append(bidOptions, "Enter an amount to add to the current price")
+
+	fmt.Println("\nHow much should be bid? You pay the spot price, not the bid, but the instance is stopped if the price rises above the bid.")
+	choice, err = w.choose("Bid", bidOptions, 1)
+	if err != nil {
+		return false, err
+	}
+
+	margin := 0.0
+	if choice < len(wizardBidMargins) {
+		margin = wizardBidMargins[choice].Margin
+	} else {
+		for {
+			answer, err := w.ask("Amount to add to the current price in USD", strconv.FormatFloat(bid, 'f', -1, 64))
+			if err != nil {
+				return false, err
+			}
+
+			if margin, err = strconv.ParseFloat(answer, 64); err == nil && margin >= 0 {
+				break
+			}
+
+			fmt.Println("Enter an amount such as 0.10.")
+		}
+	}
+
+	if volumes.HasDataVolume() {
+		defaultSize := volumes.DataVolumeSize
+		if defaultSize == 0 {
+			defaultSize = parsec.DataVolumeSize
+		}
+
+		fmt.Println("\nHow big should the data volume be? The system volume comes with it.")
+		for {
+			answer, err := w.ask("Data volume size in GB", strconv.Itoa(defaultSize))
+			if err != nil {
+				return false, err
+			}
+
+			size, err := strconv.Atoi(answer)
+			if err != nil {
+				fmt.Println("Enter a whole number of GB, such as 200.")
+				continue
+			}
+
+			sized := *volumes
+			sized.DataVolumeSize = size
+			if err := sized.Validate(); err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			volumes.DataVolumeSize = size
+			break
+		}
+	}
+
+	storage := volumes.CostPerHour(chosen.Region)
+
+	fmt.Println()
+	fmt.Printf("  Region:          %s\n", chosen.Region)
+	fmt.Printf("  Instance type:   %s (%d x %s)\n", price.InstanceType, price.Spec.GPUs, price.Spec.GPU)
+	fmt.Printf("  Bid:             $%.4f/hour, $%.2f above the current price of $%.4f/hour\n", price.Price+margin, margin, price.Price)
+	fmt.Printf("  Storage:         %s, $%.4f/hour\n", volumes, storage)
+	fmt.Printf("  Estimated cost:  $%.4f/hour at the current price\n", price.Price+storage)
+	fmt.Println()
+
+	region = chosen.Region
+	instanceType = price.InstanceType
+	bid = margin
+
+	name, err := w.ask("Save these choices as a profile? Enter a name, or leave it empty to skip", activeProfile)
+	if err != nil {
+		return false, err
+	}
+
+	if len(name) > 0 {
+		settings := [][2]string{
+			{CfgRegion, region},
+			{CfgInstanceType, instanceType},
+			{CfgBid, strconv.FormatFloat(bid, 'f', -1, 64)},
+		}
+		if volumes.HasDataVolume() {
+			settings = append(settings, [2]string{CfgDataVolumeSize, strconv.Itoa(volumes.DataVolumeSize)})
+		}
+
+		path, err := saveProfile(name, settings)
+		if err != nil {
+			return false, err
+		}
+
+		fmt.Printf("Saved profile %s in %s. Use it with 'parsec-ec2 start --profile %s'.\n", name, path, name)
+	}
+
+	return w.confirm("Start the session now?")
+}
+
+// wizardPrices looks up the current spot price of every supported instance
+// type in a region concurrently, leaving out those that aren't available.
+func wizardPrices(ctx context.Context, region string) ([]wizardPrice, error) {
+	types := parsec.GInstances()
+	specs := parsec.InstanceSpecs()
+
+	var (
+		wg     sync.WaitGroup
+		prices = make([]*wizardPrice, len(types))
+		errs   = make([]error, len(types))
+	)
+
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+
+			spotPrice, err := client.Price(ctx, parsec.PriceOptions{Region: region, InstanceType: t})
+			var unavailable *parsec.ErrInstanceTypeUnavailable
+			if errors.As(err, &unavailable) {
+				return
+			}
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			price, err := strconv.ParseFloat(aws.StringValue(spotPrice.SpotPrice), 64)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			prices[i] = &wizardPrice{InstanceType: t, Spec: specs[t], Price: price}
+		}(i, t)
+	}
+	wg.Wait()
+
+	var available []wizardPrice
+	for i := range types {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if prices[i] != nil {
+			available = append(available, *prices[i])
+		}
+	}
+
+	return available, nil
+}
+
+// saveProfile writes settings to a profile in the config file, returning the
+// path of the file.
+func saveProfile(name string, settings [][2]string) (string, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	doc, err := readConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, setting := range settings {
+		setYAMLValue(doc, []string{CfgProfiles, name, setting[0]}, configValueNode(setting[0], setting[1]))
+	}
+
+	return path, writeConfigFile(path, doc)
+}
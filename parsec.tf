@@ -1,7 +1,11 @@
 # Variables
 
+# The server key is only passed in by parsec-ec2 when making the spot request,
+# so that it never has to be written to disk. It is empty for every other
+# command, none of which need it.
 variable "server_key" {
   type = "string"
+  default = ""
 }
 
 variable "region" {
@@ -10,10 +14,26 @@ variable "region" {
 
 variable "vpc_id" {
   type = "string"
+  default = ""
 }
 
 variable "subnet_id" {
   type = "string"
+  default = ""
+}
+
+variable "create_vpc" {
+  type = "string"
+  default = "0"
+}
+
+variable "availability_zone" {
+  type = "string"
+}
+
+variable "availability_zones" {
+  type = "list"
+  default = []
 }
 
 variable "spot_price" {
@@ -32,6 +52,89 @@ variable "ip"  {
   type = "string"
 }
 
+variable "max_session_minutes" {
+  type = "string"
+  default = "0"
+}
+
+variable "idle_timeout_minutes" {
+  type = "string"
+  default = "0"
+}
+
+variable "watchdog_grace_minutes" {
+  type = "string"
+  default = "10"
+}
+
+variable "root_volume_size" {
+  type = "string"
+  default = "50"
+}
+
+variable "data_volume_count" {
+  type = "string"
+  default = "1"
+}
+
+variable "data_volume_size" {
+  type = "string"
+  default = "100"
+}
+
+variable "data_volume_type" {
+  type = "string"
+  default = "gp2"
+}
+
+variable "data_volume_iops" {
+  type = "string"
+  default = "0"
+}
+
+variable "encrypt_volumes" {
+  type = "string"
+  default = "0"
+}
+
+variable "kms_key_id" {
+  type = "string"
+  default = ""
+}
+
+# Parsec listens on the start port and the ports after it up to the end port.
+# parsec-ec2 works out both from the start port, which is also written to
+# config.txt, so that the security group always matches what Parsec uses.
+variable "parsec_start_port" {
+  type = "string"
+  default = "8000"
+}
+
+variable "parsec_end_port" {
+  type = "string"
+  default = "8040"
+}
+
+variable "parsec_config" {
+  type = "string"
+  default = ""
+}
+
+# The rendered provisioning snippets are passed in with the server key.
+variable "provision" {
+  type = "string"
+  default = ""
+}
+
+variable "session" {
+  type = "string"
+}
+
+variable "tags" {
+  type = "map"
+  default = {}
+}
+
 # Template
 
 provider "aws" {
@@ -46,14 +149,61 @@ data "aws_ami" "parsec" {
   }
 }
 
+# Network, only created when the region has no usable VPC
+
+resource "aws_vpc" "parsec" {
+  count = "${var.create_vpc}"
+  cidr_block = "10.77.0.0/16"
+  enable_dns_hostnames = true
+
+  tags = "${merge(var.tags, map("Name", "parsec-${var.session}"))}"
+}
+
+resource "aws_internet_gateway" "parsec" {
+  count = "${var.create_vpc}"
+  vpc_id = "${aws_vpc.parsec.id}"
+
+  tags = "${merge(var.tags, map("Name", "parsec-${var.session}"))}"
+}
+
+resource "aws_route_table" "parsec" {
+  count = "${var.create_vpc}"
+  vpc_id = "${aws_vpc.parsec.id}"
+
+  route {
+    cidr_block = "0.0.0.0/0"
+    gateway_id = "${aws_internet_gateway.parsec.id}"
+  }
+
+  tags = "${merge(var.tags, map("Name", "parsec-${var.session}"))}"
+}
+
+resource "aws_subnet" "parsec" {
+  count = "${var.create_vpc ? length(var.availability_zones) : 0}"
+  vpc_id = "${aws_vpc.parsec.id}"
+  availability_zone = "${element(var.availability_zones, count.index)}"
+  cidr_block = "${cidrsubnet("10.77.0.0/16", 8, count.index)}"
+  map_public_ip_on_launch = true
+
+  tags = "${merge(var.tags, map("Name", "parsec-${var.session}-${element(var.availability_zones, count.index)}"))}"
+}
+
+resource "aws_route_table_association" "parsec" {
+  count = "${var.create_vpc ? length(var.availability_zones) : 0}"
+  subnet_id = "${element(aws_subnet.parsec.*.id, count.index)}"
+  route_table_id = "${aws_route_table.parsec.id}"
+}
+
 resource "aws_security_group" "parsec" {
-  vpc_id = "${var.vpc_id}"
-  name = "parsec"
+  vpc_id = "${var.create_vpc ? join("", aws_vpc.parsec.*.id) : var.vpc_id}"
+  name = "parsec-${var.session}"
   description = "Allow inbound Parsec traffic and all outbound."
 
+  tags = "${merge(var.tags, map("Name", "parsec-${var.session}"))}"
+
   ingress {
-      from_port = 8000
-      to_port = 8040
+      from_port = "${var.parsec_start_port}"
+      to_port = "${var.parsec_end_port}"
       protocol = "tcp"
       cidr_blocks = ["${var.ip}"]
   }
@@ -73,15 +223,15 @@ resource "aws_security_group" "parsec" {
   }
 
   ingress {
-      from_port = 8000
-      to_port = 8040
+      from_port = "${var.parsec_start_port}"
+      to_port = "${var.parsec_end_port}"
       protocol = "tcp"
       cidr_blocks = ["${var.ip}"]
   }
 
   ingress {
-      from_port = 8000
-      to_port = 8040
+      from_port = "${var.parsec_start_port}"
+      to_port = "${var.parsec_end_port}"
       protocol = "udp"
       cidr_blocks = ["${var.ip}"]
   }
@@ -99,29 +249,47 @@ data "template_file" "user_data" {
 
     vars {
         server_key = "${var.server_key}"
+        max_session_minutes = "${var.max_session_minutes}"
+        idle_timeout_minutes = "${var.idle_timeout_minutes}"
+        watchdog_grace_minutes = "${var.watchdog_grace_minutes}"
+        provision = "${var.provision}"
+        parsec_start_port = "${var.parsec_start_port}"
+        parsec_config = "${var.parsec_config}"
     }
 }
 
+# IOPS of 0 are not sent to EC2, which is what gp2 volumes need.
+locals {
+  data_volume = {
+    device_name = "xvdg"
+    volume_size = "${var.data_volume_size}"
+    volume_type = "${var.data_volume_type}"
+    iops = "${var.data_volume_iops}"
+    encrypted = "${var.encrypt_volumes}"
+    kms_key_id = "${var.kms_key_id}"
+    delete_on_termination = true
+  }
+}
+
 resource "aws_spot_instance_request" "parsec" {
     spot_price = "${var.spot_price}"
     ami = "${data.aws_ami.parsec.id}"
-    subnet_id = "${var.subnet_id}"
+    subnet_id = "${var.create_vpc ? element(concat(aws_subnet.parsec.*.id, list("")), index(concat(var.availability_zones, list(var.availability_zone)), var.availability_zone)) : var.subnet_id}"
     instance_type = "${var.instance_type}"
     spot_type = "one-time"
+    instance_initiated_shutdown_behavior = "terminate"
 
-    tags {
-        Name = "ParsecServer"
-    }
+    tags = "${merge(var.tags, map("Name", "ParsecServer"))}"
 
     root_block_device {
-      volume_size = 50
+      volume_size = "${var.root_volume_size}"
+      encrypted = "${var.encrypt_volumes}"
+      kms_key_id = "${var.kms_key_id}"
     }
 
-    ebs_block_device {
-      volume_size = 100
-      volume_type = "gp2"
-      device_name = "xvdg"
-    }
+    # A block can't be left out conditionally, so the data volume is given as
+    # a list that is sliced down to nothing when there is no data volume.
+    ebs_block_device = ["${slice(list(local.data_volume), 0, var.data_volume_count)}"]
 
     user_data = "${data.template_file.user_data.rendered}"
 
@@ -129,20 +297,16 @@ resource "aws_spot_instance_request" "parsec" {
     associate_public_ip_address = true
 }
 
-output "server_key" {
-  value = "${var.server_key}"
-}
-
 output "region" {
   value = "${var.region}"
 }
 
 output "vpc_id" {
-  value = "${var.vpc_id}"
+  value = "${var.create_vpc ? join("", aws_vpc.parsec.*.id) : var.vpc_id}"
 }
 
 output "subnet_id" {
-  value = "${var.subnet_id}"
+  value = "${aws_spot_instance_request.parsec.subnet_id}"
 }
 
 output "spot_price" {
@@ -153,6 +317,10 @@ output "instance_type" {
   value = "${var.instance_type}"
 }
 
+output "spot_request_id" {
+  value = "${aws_spot_instance_request.parsec.id}"
+}
+
 output "spot_instance_id" {
   value = "${aws_spot_instance_request.parsec.spot_instance_id}"
 }
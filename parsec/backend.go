@@ -0,0 +1,133 @@
+package parsec
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Backend Types
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+	BackendHTTP  = "http"
+)
+
+// Backend is where Terraform keeps the state for sessions, along with the
+// current session itself. A remote backend lets a session started on one
+// machine be inspected and stopped from another. The zero Backend keeps
+// everything in the client's directory.
+type Backend struct {
+	Type string
+
+	// Bucket, Key and Region locate the state in S3, and DynamoDBTable is the
+	// table used to lock it.
+	Bucket        string
+	Key           string
+	Region        string
+	DynamoDBTable string
+
+	// Address is where the state is read and written over HTTP, and
+	// SessionAddress is where the current session is, defaulting to
+	// Address/session. LockAddress and UnlockAddress enable locking.
+	Address        string
+	SessionAddress string
+	LockAddress    string
+	UnlockAddress  string
+
+	// Path is where a local backend keeps the state, such as a directory that
+	// is shared between machines.
+	Path string
+}
+
+func (b Backend) validate() error {
+	switch b.Type {
+	case "":
+		return nil
+	case BackendS3:
+		if len(b.Bucket) == 0 || len(b.Key) == 0 || len(b.Region) == 0 {
+			return fmt.Errorf("An %s backend needs a bucket, key and region.", b.Type)
+		}
+	case BackendHTTP:
+		if len(b.Address) == 0 {
+			return fmt.Errorf("An %s backend needs an address.", b.Type)
+		}
+	case BackendLocal:
+		if len(b.Path) == 0 {
+			return fmt.Errorf("A %s backend needs a path.", b.Type)
+		}
+	default:
+		return fmt.Errorf("%q is not a backend type, use %s, %s or %s.", b.Type, BackendS3, BackendHTTP, BackendLocal)
+	}
+
+	return nil
+}
+
+// backendTemplate renders the backend block Terraform reads from backend.tf.
+// Backend blocks can't contain interpolations, so the settings are written
+// into the file.
+var backendTemplate = template.Must(template.New("backend").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(`# Generated by parsec-ec2 from the backend config key, don't edit.
+
+terraform {
+  backend {{ quote .Type }} {
+{{- if eq .Type "s3" }}
+    bucket  = {{ quote .Bucket }}
+    key     = {{ quote .Key }}
+    region  = {{ quote .Region }}
+    encrypt = true
+{{- if .DynamoDBTable }}
+    dynamodb_table = {{ quote .DynamoDBTable }}
+{{- end }}
+{{- else if eq .Type "http" }}
+    address = {{ quote .Address }}
+{{- if .LockAddress }}
+    lock_address = {{ quote .LockAddress }}
+{{- end }}
+{{- if .UnlockAddress }}
+    unlock_address = {{ quote .UnlockAddress }}
+{{- end }}
+{{- else if eq .Type "local" }}
+    path = {{ quote .Path }}
+{{- end }}
+  }
+}
+`))
+
+// writeBackend writes backend.tf for a remote backend, or removes it so that
+// Terraform keeps the state in the client's directory.
+func (c *Client) writeBackend() error {
+	if err := c.Backend.validate(); err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("%s/%s", c.Dir, BackendConfig)
+
+	if len(c.Backend.Type) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := backendTemplate.Execute(f, c.Backend); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// siblingPath is the path of name in the same directory as path, which may be
+// a file path or an S3 key.
+func siblingPath(path, name string) string {
+	return path[:strings.LastIndex(path, "/")+1] + name
+}
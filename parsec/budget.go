@@ -0,0 +1,107 @@
+package parsec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Budget Actions
+const (
+	BudgetActionWarn   = "warn"
+	BudgetActionRefuse = "refuse"
+)
+
+// Budget limits how much a user spends on sessions each calendar month.
+type Budget struct {
+	// Monthly is the budget in USD. Zero means there is no budget.
+	Monthly float64
+
+	// Action is BudgetActionWarn or BudgetActionRefuse.
+	Action string
+
+	// SessionHours is how long a new session is expected to run for.
+	SessionHours float64
+}
+
+// BudgetCheck is the outcome of checking a session against a budget.
+type BudgetCheck struct {
+	Budget float64
+
+	// Spent includes Running, what the user's current session has cost so
+	// far, if one is running.
+	Spent   float64
+	Running float64
+
+	Hours     float64
+	Projected float64
+	Over      bool
+}
+
+// CheckBudget projects this month's spend for the session's user if the
+// session runs for b.SessionHours at its bid price, with its volumes, and
+// compares it to the budget. What the user's current session has cost so far
+// is counted as spent, as it will be billed when the session is stopped.
+func (c *Client) CheckBudget(ctx context.Context, p *Session, b Budget) (BudgetCheck, error) {
+	if b.Monthly <= 0 {
+		return BudgetCheck{}, nil
+	}
+
+	if b.Action != BudgetActionWarn && b.Action != BudgetActionRefuse {
+		return BudgetCheck{}, fmt.Errorf("The budget action must be either %s or %s, not %q.", BudgetActionWarn, BudgetActionRefuse, b.Action)
+	}
+
+	hourly, err := strconv.ParseFloat(p.SpotPrice, 64)
+	if err != nil {
+		return BudgetCheck{}, err
+	}
+	hourly += p.Volumes.CostPerHour(p.Region)
+
+	entries, err := c.Ledger()
+	if err != nil {
+		return BudgetCheck{}, err
+	}
+
+	running, err := c.runningCost(ctx, p.User)
+	if err != nil {
+		return BudgetCheck{}, err
+	}
+
+	check := BudgetCheck{
+		Budget:  b.Monthly,
+		Spent:   MonthlySpend(entries, p.User, time.Now()) + running,
+		Running: running,
+		Hours:   b.SessionHours,
+	}
+	check.Projected = check.Spent + hourly*check.Hours
+	check.Over = check.Projected > check.Budget
+
+	return check, nil
+}
+
+// runningCost is what the current session has cost so far, if it belongs to
+// user, costed at its bid price if the spot price history can't be read.
+func (c *Client) runningCost(ctx context.Context, user string) (float64, error) {
+	p, err := c.Session(ctx)
+	if errors.Is(err, ErrNoSession) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if p.User != user || p.LaunchTime.IsZero() {
+		return 0, nil
+	}
+
+	end := time.Now().UTC()
+
+	cost, err := c.sessionCost(ctx, p, end)
+	if err != nil {
+		cost = estimateSessionCost(p, end)
+	}
+
+	return cost.Total(), nil
+}
@@ -0,0 +1,578 @@
+// Package parsec starts, inspects and stops Parsec sessions on EC2 spot
+// instances. It is the library behind the parsec-ec2 command line tool, and
+// works with the Terraform templates and session files in a directory that
+// has been set up with Client.Init.
+//
+// Nothing in this package prints to the console or exits. Problems that
+// don't stop an operation from completing are returned as warnings on its
+// result, and Terraform output is written to Client.Stdout.
+package parsec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Client manages the sessions for a single parsec-ec2 directory, usually
+// $HOME/.parsec-ec2.
+type Client struct {
+	// Dir holds the Terraform templates, state and session files.
+	Dir string
+
+	// Stdout receives the output of 'terraform plan', and Stdout and Stderr
+	// receive the output of every Terraform command when Verbose is set.
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Verbose bool
+
+	// Backend is where the Terraform state and the current session are kept.
+	// Init must be run again after it is changed.
+	Backend Backend
+
+	// AWS selects the credentials used for AWS calls, which are passed on to
+	// Terraform.
+	AWS AWSConfig
+
+	// InterruptGrace is how long Terraform is given to stop cleanly after the
+	// context it was started with is cancelled, before it is killed.
+	InterruptGrace time.Duration
+
+	sessions awsSessions
+}
+
+// NewClient returns a client for the sessions in dir.
+func NewClient(dir string) *Client {
+	return &Client{Dir: dir, Stdout: ioutil.Discard, Stderr: ioutil.Discard, InterruptGrace: DefaultInterruptGrace}
+}
+
+// Init creates the client's directory if needed, copies the Terraform
+// templates from templateDir into it, writes the configuration for the
+// client's backend and runs 'terraform init'. It is run after installing,
+// after every upgrade and whenever the backend changes, in which case any
+// existing state is copied to the new backend.
+func (c *Client) Init(ctx context.Context, templateDir string) error {
+	if _, err := os.Stat(c.Dir); os.IsNotExist(err) {
+		if err := os.Mkdir(c.Dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range []string{Template, Userdata} {
+		src := fmt.Sprintf("%s/%s", templateDir, file)
+		dst := fmt.Sprintf("%s/%s", c.Dir, file)
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+
+	if err := c.writeBackend(); err != nil {
+		return err
+	}
+
+	command, err := c.tfCmd(ctx, []string{TfCmdInit, TfFlagNoInput, TfFlagCopy, TfFlagNoColor})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.run(ctx, command, InitLog)
+	return err
+}
+
+// PriceOptions selects the spot price to look up.
+type PriceOptions struct {
+	Region       string
+	InstanceType string
+}
+
+// Price returns the highest current Windows spot price for an instance type
+// in a region.
+func (c *Client) Price(ctx context.Context, opts PriceOptions) (ec2.SpotPrice, error) {
+	if err := validate(opts.Region, opts.InstanceType); err != nil {
+		return ec2.SpotPrice{}, err
+	}
+
+	svc, err := c.newEc2Client(opts.Region)
+	if err != nil {
+		return ec2.SpotPrice{}, err
+	}
+
+	return getSpotPrice(ctx, svc, opts.InstanceType)
+}
+
+// StartOptions describes the session to start.
+type StartOptions struct {
+	Region       string
+	InstanceType string
+
+	// Bid is added to the current highest spot price to give the bid price.
+	Bid float64
+
+	// ServerKey is where the Parsec server key is read from. It is only read
+	// by Prepare and is never written to disk by parsec-ec2.
+	ServerKey SecretSource
+
+	// Session names the session, and User is who it is recorded under. They
+	// default to <user>-<timestamp> and the local login name.
+	Session string
+	User    string
+
+	// Tags are added to the tags parsec-ec2 puts on every resource.
+	Tags map[string]string
+
+	// VpcID launches the session into an existing VPC instead of the region's
+	// default VPC, and CreateVpc has Terraform create one for the session.
+	VpcID     string
+	CreateVpc bool
+
+	// IPResolver finds the address allowed through the security group. The
+	// default providers and STUN servers are used if it is nil.
+	IPResolver IPResolver
+
+	// MaxDuration and IdleTimeout configure the watchdog on the instance.
+	// Zero means no limit.
+	MaxDuration time.Duration
+	IdleTimeout time.Duration
+
+	// Volumes are the sizes, type and encryption of the instance's volumes.
+	Volumes Volumes
+
+	// Host are the Parsec host settings, such as the start port.
+	Host HostSettings
+
+	Budget Budget
+}
+
+// StartResult describes a session whose spot request has been made.
+type StartResult struct {
+	Session *Session
+	Budget  BudgetCheck
+
+	// InstanceID is the spot instance, if the request was fulfilled in time
+	// for it to be tagged.
+	InstanceID string
+
+	Warnings []error
+}
+
+// ApplyResult describes a session whose spot request has been made.
+type ApplyResult struct {
+	Session *Session
+
+	// InstanceID is the spot instance, if the request was fulfilled within
+	// SpotFulfillmentTimeout, in which case it and its volumes have been
+	// tagged with the session tags.
+	InstanceID string
+
+	Warnings []error
+}
+
+func validate(region, instanceType string) error {
+	if !IsValidRegion(Regions(), region) {
+		return &ErrInvalidRegion{Region: region}
+	}
+
+	if !IsValidGInstance(GInstances(), instanceType) {
+		return &ErrInvalidInstanceType{InstanceType: instanceType}
+	}
+
+	return nil
+}
+
+// Prepare works out the Terraform variables for a session: the bid price, the
+// network to launch into, the address to allow, the watchdog limits and the
+// provisioning snippets to run.
+// Nothing is created until the session is passed to Apply.
+func (c *Client) Prepare(ctx context.Context, opts StartOptions) (*Session, error) {
+	if err := validate(opts.Region, opts.InstanceType); err != nil {
+		return nil, err
+	}
+
+	if opts.CreateVpc && len(opts.VpcID) > 0 {
+		return nil, errors.New("Use either a VPC id or create a VPC, not both.")
+	}
+
+	if len(opts.Session) > 0 {
+		if err := validateSessionName(opts.Session); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := opts.Volumes.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Host.Validate(); err != nil {
+		return nil, err
+	}
+
+	if opts.ServerKey == nil {
+		return nil, ErrNoServerKey
+	}
+
+	serverKey, err := opts.ServerKey.Secret(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(serverKey) == 0 {
+		return nil, ErrNoServerKey
+	}
+
+	svc, err := c.newEc2Client(opts.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Session{ServerKey: serverKey}
+
+	if err := p.calculate(ctx, svc, opts); err != nil {
+		return nil, err
+	}
+
+	if err := p.setWatchdog(opts.MaxDuration, opts.IdleTimeout); err != nil {
+		return nil, err
+	}
+
+	if err := c.provision(ctx, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Apply makes the spot request for a prepared session, saves it as the
+// current session and records its start in the ledger. ErrSessionExists is
+// returned if a session is already running. Spot request tags aren't passed
+// on to the instance, so Apply waits for the request to be fulfilled and tags
+// the instance and its volumes, returning a warning if it can't.
+//
+// The session is saved as pending before Terraform runs, so that if apply
+// fails or is interrupted part way through, whatever it created can still be
+// destroyed with Stop.
+func (c *Client) Apply(ctx context.Context, p *Session) (*ApplyResult, error) {
+	unlock, err := c.lock(LockOperationApply)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := c.Session(ctx); !errors.Is(err, ErrNoSession) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionExists
+	}
+
+	return c.apply(ctx, p, func() error {
+		_, err := c.terraform(ctx, p, TfCmdApply)
+		return err
+	})
+}
+
+func (c *Client) apply(ctx context.Context, p *Session, apply func() error) (*ApplyResult, error) {
+	if err := validateSessionName(p.Session); err != nil {
+		return nil, err
+	}
+
+	if err := p.Volumes.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := p.HostSettings.Validate(); err != nil {
+		return nil, err
+	}
+
+	p.LaunchTime = time.Now().UTC()
+	p.Pending = true
+	if err := c.writeSession(ctx, p); err != nil {
+		return nil, err
+	}
+
+	if err := apply(); err != nil {
+		return nil, err
+	}
+
+	p.Pending = false
+	if err := c.writeSession(ctx, p); err != nil {
+		return nil, err
+	}
+
+	if err := c.appendLedger(newStartLedgerEntry(p)); err != nil {
+		return nil, err
+	}
+
+	r := ApplyResult{Session: p}
+
+	instanceID, err := c.tagSpotInstance(ctx, p)
+	if err != nil {
+		r.Warnings = append(r.Warnings, fmt.Errorf("The instance and its volumes haven't been tagged yet, and will be tagged by 'parsec-ec2 status' once the spot request has been fulfilled: %s", err))
+	}
+	r.InstanceID = instanceID
+
+	return &r, nil
+}
+
+// tagSpotInstance waits up to SpotFulfillmentTimeout for the session's spot
+// request to be fulfilled, and then propagates the session tags to the
+// instance and its volumes.
+func (c *Client) tagSpotInstance(ctx context.Context, p *Session) (string, error) {
+	svc, err := c.newEc2Client(p.Region)
+	if err != nil {
+		return "", err
+	}
+
+	o, err := c.outputs(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	input := &ec2.DescribeSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: []*string{aws.String(o.SpotRequestID.Value)},
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, SpotFulfillmentTimeout)
+	defer cancel()
+
+	if err := svc.WaitUntilSpotInstanceRequestFulfilledWithContext(waitCtx, input); err != nil {
+		return "", err
+	}
+
+	result, err := svc.DescribeSpotInstanceRequestsWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.SpotInstanceRequests) == 0 || result.SpotInstanceRequests[0].InstanceId == nil {
+		return "", fmt.Errorf("The spot request %s has no instance.", o.SpotRequestID.Value)
+	}
+
+	instanceID := aws.StringValue(result.SpotInstanceRequests[0].InstanceId)
+
+	return instanceID, propagateTags(ctx, svc, instanceID, p.Tags)
+}
+
+// Start prepares a session, checks it against opts.Budget and applies it. A
+// session over a budget with BudgetActionRefuse returns ErrOverBudget, with
+// BudgetActionWarn the check is returned on the result for the caller to show.
+func (c *Client) Start(ctx context.Context, opts StartOptions) (*StartResult, error) {
+	p, err := c.Prepare(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := c.CheckBudget(ctx, p, opts.Budget)
+	if err != nil {
+		return nil, err
+	}
+
+	if check.Over && opts.Budget.Action == BudgetActionRefuse {
+		return nil, &ErrOverBudget{Check: check}
+	}
+
+	r, err := c.Apply(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StartResult{Session: p, Budget: check, InstanceID: r.InstanceID, Warnings: r.Warnings}, nil
+}
+
+// Status describes the current session.
+type Status struct {
+	Session *Session
+
+	// Cost is what the session has cost so far, or nil if it can't be
+	// calculated.
+	Cost *SessionCost
+
+	SpotRequestID  string
+	SpotInstanceID string
+	SpotBidStatus  string
+
+	// InstanceStatus is empty until EC2 reports the instance's status checks.
+	InstanceStatus string
+
+	// Provision is the provisioning progress the instance has reported on
+	// its console, or nil if it hasn't reported any yet.
+	Provision *ProvisionProgress
+
+	Warnings []error
+}
+
+// Fulfilled reports whether the spot request has launched an instance.
+func (s *Status) Fulfilled() bool {
+	return len(s.SpotInstanceID) > 0
+}
+
+// Initialised reports whether the instance has passed its status checks.
+func (s *Status) Initialised() bool {
+	return s.InstanceStatus == OK
+}
+
+// Status refreshes the Terraform state for the current session and queries
+// EC2 for the status of its spot request and instance. Once the instance has
+// launched, the session tags are propagated to it and its volumes again, in
+// case the spot request wasn't fulfilled in time for Apply to tag them.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	unlock, err := c.lock(LockOperationStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	plansErr := c.removeExpiredPlans()
+
+	p, err := c.Session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Status{Session: p}
+
+	if plansErr != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("Could not remove the expired plans in %s/%s: %s", c.Dir, Plans, plansErr))
+	}
+
+	if p.Pending {
+		s.Warnings = append(s.Warnings, errors.New("The spot request for this session failed or was interrupted, so it may be incomplete. Stop the session to clean up whatever was created."))
+	}
+
+	svc, err := c.newEc2Client(p.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.LaunchTime.IsZero() {
+		cost, err := calculateSessionCost(ctx, svc, p, time.Now().UTC())
+		if err != nil {
+			s.Warnings = append(s.Warnings, fmt.Errorf("Could not calculate the cost of this session: %s", err))
+		} else {
+			s.Cost = &cost
+		}
+	}
+
+	if _, err := c.terraform(ctx, p, TfCmdRefresh); err != nil {
+		return nil, err
+	}
+
+	o, err := c.outputs(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	s.SpotRequestID = o.SpotRequestID.Value
+	s.SpotInstanceID = o.SpotInstanceID.Value
+	s.SpotBidStatus = o.SpotBidStatus.Value
+
+	if !s.Fulfilled() {
+		return &s, nil
+	}
+
+	if err := propagateTags(ctx, svc, s.SpotInstanceID, p.Tags); err != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("Could not tag the instance and its volumes: %s", err))
+	}
+
+	result, err := svc.DescribeInstanceStatusWithContext(ctx, &ec2.DescribeInstanceStatusInput{
+		InstanceIds: []*string{aws.String(s.SpotInstanceID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.InstanceStatuses) > 0 {
+		s.InstanceStatus = aws.StringValue(result.InstanceStatuses[0].InstanceStatus.Status)
+	}
+
+	progress, err := provisionProgress(ctx, svc, s.SpotInstanceID)
+	if err != nil {
+		s.Warnings = append(s.Warnings, fmt.Errorf("Could not read the provisioning progress from the console output: %s", err))
+	}
+	s.Provision = progress
+
+	return &s, nil
+}
+
+// StopResult describes a session that has been stopped.
+type StopResult struct {
+	Session *Session
+
+	// Cost is the final bill for the session, or nil if it can't be
+	// calculated.
+	Cost *SessionCost
+
+	Warnings []error
+}
+
+// Stop destroys every resource created for the current session, bills it to
+// the ledger and removes the session file.
+func (c *Client) Stop(ctx context.Context) (*StopResult, error) {
+	unlock, err := c.lock(LockOperationStop)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	p, err := c.Session(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := StopResult{Session: p}
+
+	if _, err := c.terraform(ctx, p, TfCmdDestroy, TfFlagForce); err != nil {
+		return nil, err
+	}
+
+	if p.LaunchTime.IsZero() {
+		r.Warnings = append(r.Warnings, errors.New("This session was started without a launch time, so its cost can't be calculated."))
+	} else {
+		cost, err := c.billSession(ctx, p)
+		if cost.Estimated {
+			r.Warnings = append(r.Warnings, errors.New("The spot price history for this session couldn't be read, so it has been billed at its bid price."))
+		}
+		if err != nil {
+			r.Warnings = append(r.Warnings, fmt.Errorf("Could not record the cost of this session in the ledger: %s", err))
+		}
+		r.Cost = &cost
+	}
+
+	if err := c.removeSession(ctx); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// billSession appends the final bill for a session to the ledger. If the
+// cost can't be calculated, the session is billed at its bid price, so that
+// its spend still counts towards the budget.
+func (c *Client) billSession(ctx context.Context, p *Session) (SessionCost, error) {
+	end := time.Now().UTC()
+
+	cost, err := c.sessionCost(ctx, p, end)
+	entry := newLedgerEntry(p, cost)
+	if err != nil {
+		cost = estimateSessionCost(p, end)
+		entry = newLedgerEntry(p, cost)
+		entry.Error = err.Error()
+	}
+
+	return cost, c.appendLedger(entry)
+}
+
+func (c *Client) sessionCost(ctx context.Context, p *Session, end time.Time) (SessionCost, error) {
+	svc, err := c.newEc2Client(p.Region)
+	if err != nil {
+		return SessionCost{}, err
+	}
+
+	return calculateSessionCost(ctx, svc, p, end)
+}
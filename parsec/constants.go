@@ -0,0 +1,270 @@
+package parsec
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Version of parsec-ec2, recorded on the resources it creates
+const Version = "0.2.0"
+
+// Terraform command
+const Terraform = "terraform"
+
+// DefaultInterruptGrace is how long Terraform is given to stop cleanly when
+// an operation is cancelled.
+const DefaultInterruptGrace = 2 * time.Minute
+
+// SpotFulfillmentTimeout is how long Apply waits for a spot request to be
+// fulfilled so that it can tag the instance.
+const SpotFulfillmentTimeout = 5 * time.Minute
+
+// HTTPStoreTimeout is how long a request to an http backend's session store
+// can take, so that an unresponsive server can't hang every command.
+const HTTPStoreTimeout = 30 * time.Second
+
+// Security groups and subnets can't be deleted until EC2 has released the
+// network interfaces of their terminated instances, so deleting one is
+// retried, after DeleteRetryDelay and then twice as long each time.
+const (
+	DeleteAttempts   = 6
+	DeleteRetryDelay = 5 * time.Second
+)
+
+// Terraform CLI Commands
+const (
+	TfCmdApply   = "apply"
+	TfCmdDestroy = "destroy"
+	TfCmdInit    = "init"
+	TfCmdOutput  = "output"
+	TfCmdPlan    = "plan"
+	TfCmdRefresh = "refresh"
+)
+
+// Parsec Terraform Template Outputs
+const (
+	TfOutputInstanceType   = "instance_type"
+	TfOutputRegion         = "region"
+	TfOutputSpotInstanceID = "spot_instance_id"
+	TfOutputSpotRequestID  = "spot_request_id"
+	TfOutputSpotPrice      = "spot_price"
+	TfOutputSubnetID       = "subnet_id"
+	TfOutputVpcID          = "vpc_id"
+)
+
+// Terraform CLI Command Flags
+const (
+	TfFlagForce   = "-force"
+	TfFlagJSON    = "-json"
+	TfFlagNoColor = "-no-color"
+	TfFlagVarFile = "-var-file"
+	TfFlagOut     = "-out"
+	TfFlagNoInput = "-input=false"
+	TfFlagCopy    = "-force-copy"
+)
+
+// Filenames
+const (
+	Template       = "parsec.tf"
+	Userdata       = "user_data.tmpl"
+	CurrentSession = "currentSession.json"
+	BackendConfig  = "backend.tf"
+	Ledger         = "ledger.jsonl"
+	Logs           = "logs"
+	TfState        = "terraform.tfstate"
+	TfStateBackup  = "terraform.tfstate.backup"
+	Sessions       = "sessions"
+	Plans          = "plans"
+	PlanFile       = "plan.tfplan"
+	PlanSession    = "plan.json"
+	SessionLock    = "session.lock"
+	LatencyCache   = "latency.json"
+
+	ProvisionSnippetsDir = "provision.d"
+)
+
+// MaxSessionNameLength is the longest session name allowed.
+const MaxSessionNameLength = 64
+
+// InitLog is the log 'terraform init' writes to, and LegacySessionName is the
+// name given to the files of sessions started before sessions were named.
+const (
+	InitLog           = "init"
+	LegacySessionName = "session"
+)
+
+// EBS Volume Sizes in GB. RootVolumeSize and DataVolumeSize are the default
+// sizes, and the sizes of the volumes of sessions started before they could
+// be changed. The Parsec AMI needs a root volume of at least
+// MinRootVolumeSize.
+const (
+	RootVolumeSize    = 50
+	DataVolumeSize    = 100
+	MinRootVolumeSize = 30
+	MinIO1VolumeSize  = 4
+	MaxVolumeSize     = 16384
+)
+
+// EBS Volume Types. VolumeTypeNone launches the instance without a data
+// volume. Sessions started before the type could be changed have a gp2 data
+// volume. gp3 and io2 volumes need version 3 of the AWS provider, which
+// Terraform 0.10 can't run, so sessions can't use them, but gc still prices
+// the ones it finds.
+const (
+	VolumeTypeGP2  = "gp2"
+	VolumeTypeIO1  = "io1"
+	VolumeTypeGP3  = "gp3"
+	VolumeTypeIO2  = "io2"
+	VolumeTypeNone = "none"
+)
+
+// EBS Volume Performance Limits. io1 volumes are always provisioned,
+// DefaultIO1IOPS unless another figure is given, and gp3 volumes include
+// GP3BaselineIOPS and GP3BaselineThroughput in MiB/s.
+const (
+	DefaultIO1IOPS        = 3000
+	MinIO1IOPS            = 100
+	MaxIO1IOPS            = 64000
+	MaxIO1IOPSPerGB       = 50
+	GP3BaselineIOPS       = 3000
+	GP3BaselineThroughput = 125
+)
+
+// Parsec Host Limits. Parsec listens on the start port and the
+// ParsecPortRange ports after it, which the security group opens.
+const (
+	DefaultParsecStartPort = 8000
+	ParsecPortRange        = 40
+	MinParsecStartPort     = 1024
+	MaxPort                = 65535
+	MaxParsecBitrate       = 50
+	MaxParsecHostName      = 63
+	MinParsecWidth         = 640
+	MinParsecHeight        = 480
+	MaxParsecWidth         = 7680
+	MaxParsecHeight        = 4320
+)
+
+// AWS Credential Defaults. DefaultAWSRegion is only used to assume roles, as
+// every other call is made in a session's region.
+const (
+	DefaultAWSRegion          = "us-east-1"
+	DefaultAssumeRoleDuration = time.Hour
+)
+
+// Budget Defaults
+const DefaultBudgetSessionHours = 3
+
+// External IP Lookup Defaults
+const DefaultIPTimeout = 5 * time.Second
+
+func DefaultIPProviders() []string {
+	return []string{
+		"https://ipv4.icanhazip.com",
+		"https://checkip.amazonaws.com",
+		"https://api.ipify.org",
+	}
+}
+
+func DefaultSTUNServers() []string {
+	return []string{
+		"stun.l.google.com:19302",
+		"stun.cloudflare.com:3478",
+	}
+}
+
+// Resource Names, matching parsec.tf. Security groups are now named
+// parsec-<session>, LegacySecurityGroupName is what older versions used.
+const (
+	LegacySecurityGroupName = "parsec"
+	InstanceName            = "ParsecServer"
+)
+
+// Resource Tags
+const (
+	TagName    = "Name"
+	TagSession = "parsec-ec2:session"
+	TagUser    = "parsec-ec2:user"
+	TagCreated = "parsec-ec2:created"
+	TagVersion = "parsec-ec2:version"
+)
+
+// Product Description and Instance Statuses
+const (
+	Windows = "Windows"
+	OK      = "ok"
+)
+
+// Regions are the AWS regions EC2 instances can be launched in.
+func Regions() map[string]endpoints.Region {
+	partition := endpoints.AwsPartition()
+	services := partition.Services()
+	ec2 := services[endpoints.Ec2ServiceID]
+	return ec2.Regions()
+}
+
+// RegionIDs are the ids of the Regions, sorted.
+func RegionIDs() []string {
+	var ids []string
+	for _, r := range Regions() {
+		ids = append(ids, r.ID())
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func IsValidRegion(validRegions map[string]endpoints.Region, input string) bool {
+	for _, valid := range validRegions {
+		if input == valid.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// GInstances are the GPU instance types that Parsec AMIs are available for.
+func GInstances() []string {
+	return []string{
+		ec2.InstanceTypeG22xlarge,
+		ec2.InstanceTypeG28xlarge,
+		ec2.InstanceTypeG34xlarge,
+		ec2.InstanceTypeG38xlarge,
+		ec2.InstanceTypeG316xlarge,
+		ec2.InstanceTypeP32xlarge,
+		ec2.InstanceTypeG4dn2xlarge,
+	}
+}
+
+// InstanceSpec describes the hardware of a GPU instance type.
+type InstanceSpec struct {
+	GPU       string
+	GPUs      int
+	VCPUs     int
+	MemoryGiB float64
+}
+
+// InstanceSpecs are the specifications of the GInstances.
+func InstanceSpecs() map[string]InstanceSpec {
+	return map[string]InstanceSpec{
+		ec2.InstanceTypeG22xlarge:   {GPU: "NVIDIA GRID K520", GPUs: 1, VCPUs: 8, MemoryGiB: 15},
+		ec2.InstanceTypeG28xlarge:   {GPU: "NVIDIA GRID K520", GPUs: 4, VCPUs: 32, MemoryGiB: 60},
+		ec2.InstanceTypeG34xlarge:   {GPU: "NVIDIA Tesla M60", GPUs: 1, VCPUs: 16, MemoryGiB: 122},
+		ec2.InstanceTypeG38xlarge:   {GPU: "NVIDIA Tesla M60", GPUs: 2, VCPUs: 32, MemoryGiB: 244},
+		ec2.InstanceTypeG316xlarge:  {GPU: "NVIDIA Tesla M60", GPUs: 4, VCPUs: 64, MemoryGiB: 488},
+		ec2.InstanceTypeP32xlarge:   {GPU: "NVIDIA Tesla V100", GPUs: 1, VCPUs: 8, MemoryGiB: 61},
+		ec2.InstanceTypeG4dn2xlarge: {GPU: "NVIDIA T4", GPUs: 1, VCPUs: 8, MemoryGiB: 32},
+	}
+}
+
+func IsValidGInstance(validInstances []string, input string) bool {
+	for _, valid := range validInstances {
+		if input == valid {
+			return true
+		}
+	}
+	return false
+}
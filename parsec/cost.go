@@ -0,0 +1,211 @@
+package parsec
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// hoursPerMonth is the figure AWS uses when prorating GB-month storage charges.
+const hoursPerMonth = 730
+
+// Windows spot instances are billed per second with a one minute minimum.
+const minimumBilledDuration = time.Minute
+
+// gp2 EBS prices in USD per GB-month. Regions that are not listed fall back
+// to the us-east-1 price, which is the cheapest and so will underestimate.
+// Other volume types are priced from their us-east-1 prices below, scaled by
+// how much more gp2 costs in the region.
+var ebsPricesPerGBMonth = map[string]float64{
+	"us-east-1":      0.10,
+	"us-east-2":      0.10,
+	"us-west-1":      0.12,
+	"us-west-2":      0.10,
+	"ca-central-1":   0.11,
+	"eu-west-1":      0.11,
+	"eu-west-2":      0.116,
+	"eu-west-3":      0.116,
+	"eu-central-1":   0.119,
+	"ap-northeast-1": 0.12,
+	"ap-northeast-2": 0.114,
+	"ap-southeast-1": 0.12,
+	"ap-southeast-2": 0.12,
+	"ap-south-1":     0.114,
+	"sa-east-1":      0.19,
+}
+
+func ebsPricePerGBMonth(region string) float64 {
+	if price, ok := ebsPricesPerGBMonth[region]; ok {
+		return price
+	}
+	return ebsPricesPerGBMonth["us-east-1"]
+}
+
+// us-east-1 EBS prices in USD per GB-month, per provisioned IOPS-month and
+// per provisioned MiB/s-month. gp3 volumes are only charged for performance
+// above their baseline.
+const (
+	io1PricePerGBMonth         = 0.125
+	io1PricePerIOPSMonth       = 0.065
+	gp3PricePerGBMonth         = 0.08
+	gp3PricePerIOPSMonth       = 0.005
+	gp3PricePerThroughputMonth = 0.04
+	io2PricePerGBMonth         = 0.125
+	io2PricePerIOPSMonth       = 0.065
+	io2PricePerIOPSMonthOver32 = 0.0455
+	io2DiscountedIOPS          = 32000
+)
+
+// ebsMonthlyCost is roughly what a volume costs per month in a region.
+// Types other than io1, gp3 and io2 are priced as gp2.
+func ebsMonthlyCost(region, volumeType string, sizeGB, iops, throughput int) float64 {
+	gp2 := ebsPricePerGBMonth(region)
+	scale := gp2 / ebsPricesPerGBMonth["us-east-1"]
+
+	switch volumeType {
+	case VolumeTypeIO1:
+		return (float64(sizeGB)*io1PricePerGBMonth + float64(iops)*io1PricePerIOPSMonth) * scale
+	case VolumeTypeGP3:
+		cost := float64(sizeGB) * gp3PricePerGBMonth
+		if iops > GP3BaselineIOPS {
+			cost += float64(iops-GP3BaselineIOPS) * gp3PricePerIOPSMonth
+		}
+		if throughput > GP3BaselineThroughput {
+			cost += float64(throughput-GP3BaselineThroughput) * gp3PricePerThroughputMonth
+		}
+		return cost * scale
+	case VolumeTypeIO2:
+		cost := float64(sizeGB) * io2PricePerGBMonth
+		if iops > io2DiscountedIOPS {
+			cost += io2DiscountedIOPS*io2PricePerIOPSMonth + float64(iops-io2DiscountedIOPS)*io2PricePerIOPSMonthOver32
+		} else {
+			cost += float64(iops) * io2PricePerIOPSMonth
+		}
+		return cost * scale
+	}
+
+	return float64(sizeGB) * gp2
+}
+
+// SessionCost is the cost of a session between its launch and End.
+type SessionCost struct {
+	Start        time.Time
+	End          time.Time
+	InstanceCost float64
+	StorageCost  float64
+
+	// Estimated is set when the spot price history couldn't be read and the
+	// instance has been costed at the session's bid price instead, which is
+	// the most it can have cost.
+	Estimated bool
+}
+
+func (c SessionCost) Duration() time.Duration {
+	return c.End.Sub(c.Start)
+}
+
+func (c SessionCost) Total() float64 {
+	return c.InstanceCost + c.StorageCost
+}
+
+func calculateSessionCost(ctx context.Context, svc *ec2.EC2, p *Session, end time.Time) (SessionCost, error) {
+	cost := SessionCost{Start: p.LaunchTime, End: end}
+
+	if end.Sub(p.LaunchTime) < minimumBilledDuration {
+		end = p.LaunchTime.Add(minimumBilledDuration)
+	}
+
+	history, err := getSpotPriceHistory(ctx, svc, p, end)
+	if err != nil {
+		return SessionCost{}, err
+	}
+
+	cost.InstanceCost = integrateSpotPrices(history, p.LaunchTime, end)
+	cost.StorageCost = p.Volumes.CostPerHour(p.Region) * end.Sub(p.LaunchTime).Hours()
+
+	return cost, nil
+}
+
+// estimateSessionCost costs a session at its bid price, for when its spot
+// price history can't be read.
+func estimateSessionCost(p *Session, end time.Time) SessionCost {
+	cost := SessionCost{Start: p.LaunchTime, End: end, Estimated: true}
+
+	if end.Sub(p.LaunchTime) < minimumBilledDuration {
+		end = p.LaunchTime.Add(minimumBilledDuration)
+	}
+
+	hours := end.Sub(p.LaunchTime).Hours()
+	bid, _ := strconv.ParseFloat(p.SpotPrice, 64)
+
+	cost.InstanceCost = bid * hours
+	cost.StorageCost = p.Volumes.CostPerHour(p.Region) * hours
+
+	return cost
+}
+
+func getSpotPriceHistory(ctx context.Context, svc *ec2.EC2, p *Session, end time.Time) ([]*ec2.SpotPrice, error) {
+	input := ec2.DescribeSpotPriceHistoryInput{
+		AvailabilityZone:    aws.String(p.AvailabilityZone),
+		InstanceTypes:       []*string{aws.String(p.InstanceType)},
+		ProductDescriptions: []*string{aws.String(Windows)},
+		StartTime:           aws.Time(p.LaunchTime),
+		EndTime:             aws.Time(end),
+	}
+
+	var history []*ec2.SpotPrice
+	err := svc.DescribeSpotPriceHistoryPagesWithContext(ctx, &input, func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+		history = append(history, page.SpotPriceHistory...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(history) == 0 {
+		return nil, fmt.Errorf("No spot price history found for %s in %s.", p.InstanceType, p.AvailabilityZone)
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Timestamp.Before(*history[j].Timestamp)
+	})
+
+	return history, nil
+}
+
+// integrateSpotPrices adds up the hourly price in effect over each part of
+// the period between start and end. The history is sorted oldest first, and
+// the first price is assumed to have been in effect from the start.
+func integrateSpotPrices(history []*ec2.SpotPrice, start, end time.Time) float64 {
+	var total float64
+
+	for i, price := range history {
+		from := *price.Timestamp
+		if i == 0 || from.Before(start) {
+			from = start
+		}
+
+		to := end
+		if i+1 < len(history) && history[i+1].Timestamp.Before(end) {
+			to = *history[i+1].Timestamp
+		}
+
+		if !to.After(from) {
+			continue
+		}
+
+		hourly, err := strconv.ParseFloat(*price.SpotPrice, 64)
+		if err != nil {
+			continue
+		}
+
+		total += hourly * to.Sub(from).Hours()
+	}
+
+	return total
+}
@@ -0,0 +1,122 @@
+package parsec
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIntegrateSpotPrices(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	price := func(minutes int, hourly string) *ec2.SpotPrice {
+		return &ec2.SpotPrice{Timestamp: aws.Time(at(minutes)), SpotPrice: aws.String(hourly)}
+	}
+
+	tests := []struct {
+		name    string
+		history []*ec2.SpotPrice
+		end     time.Time
+		want    float64
+	}{
+		{
+			name:    "one price for the whole session",
+			history: []*ec2.SpotPrice{price(-30, "0.50")},
+			end:     at(120),
+			want:    1.00,
+		},
+		{
+			name:    "first price is assumed from the start",
+			history: []*ec2.SpotPrice{price(30, "0.60")},
+			end:     at(60),
+			want:    0.60,
+		},
+		{
+			name:    "price change during the session",
+			history: []*ec2.SpotPrice{price(-10, "0.40"), price(30, "0.80")},
+			end:     at(60),
+			want:    0.20 + 0.40,
+		},
+		{
+			name:    "price change after the end is ignored",
+			history: []*ec2.SpotPrice{price(-10, "0.40"), price(90, "9.00")},
+			end:     at(60),
+			want:    0.40,
+		},
+		{
+			name:    "unparseable price is skipped",
+			history: []*ec2.SpotPrice{price(-10, "0.40"), price(30, "n/a")},
+			end:     at(60),
+			want:    0.20,
+		},
+		{
+			name: "no history",
+			end:  at(60),
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := integrateSpotPrices(tt.history, start, tt.end)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("integrateSpotPrices() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateSessionCost(t *testing.T) {
+	launch := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	p := &Session{
+		LaunchTime: launch,
+		Region:     "us-east-1",
+		SpotPrice:  "0.5",
+		Volumes:    Volumes{DataVolumeType: VolumeTypeNone},
+	}
+
+	cost := estimateSessionCost(p, launch.Add(2*time.Hour))
+	if !cost.Estimated {
+		t.Error("estimateSessionCost() is not marked as estimated")
+	}
+	if math.Abs(cost.InstanceCost-1.0) > 1e-9 {
+		t.Errorf("InstanceCost = %f, want 1.0", cost.InstanceCost)
+	}
+
+	// The default 50 GB gp2 root volume at $0.10 per GB-month.
+	wantStorage := 50 * 0.10 / hoursPerMonth * 2
+	if math.Abs(cost.StorageCost-wantStorage) > 1e-9 {
+		t.Errorf("StorageCost = %f, want %f", cost.StorageCost, wantStorage)
+	}
+
+	short := estimateSessionCost(p, launch.Add(time.Second))
+	if want := 0.5 * minimumBilledDuration.Hours(); math.Abs(short.InstanceCost-want) > 1e-9 {
+		t.Errorf("InstanceCost for a one second session = %f, want the minimum %f", short.InstanceCost, want)
+	}
+}
+
+func TestEBSMonthlyCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		volumeType string
+		size, iops int
+		throughput int
+		want       float64
+	}{
+		{name: "gp2", volumeType: VolumeTypeGP2, size: 100, want: 100 * 0.10},
+		{name: "io1", volumeType: VolumeTypeIO1, size: 200, iops: 4000, want: 200*0.125 + 4000*0.065},
+		{name: "gp3 at its baseline", volumeType: VolumeTypeGP3, size: 100, iops: GP3BaselineIOPS, throughput: GP3BaselineThroughput, want: 100 * 0.08},
+		{name: "unknown types are priced as gp2", volumeType: "st1", size: 100, want: 100 * 0.10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ebsMonthlyCost("us-east-1", tt.volumeType, tt.size, tt.iops, tt.throughput); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("ebsMonthlyCost() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
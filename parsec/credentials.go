@@ -0,0 +1,126 @@
+package parsec
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// AWSConfig selects the credentials parsec-ec2 and Terraform use. The zero
+// value uses the default credential chain: the environment, the default
+// shared profile and then the instance role.
+type AWSConfig struct {
+	// Profile is a profile in the shared AWS config and credentials files.
+	// A profile that assumes a role itself, with role_arn and mfa_serial, is
+	// supported too.
+	Profile string
+
+	// RoleARN is a role assumed with the profile's credentials, and
+	// ExternalID is passed when assuming it if the role requires one.
+	RoleARN    string
+	ExternalID string
+
+	// MFASerial is the MFA device needed to assume the role, and
+	// TokenProvider is asked for its current code.
+	MFASerial     string
+	TokenProvider func() (string, error)
+
+	// SessionDuration is how long the assumed role's credentials last.
+	// DefaultAssumeRoleDuration is used if it is zero.
+	SessionDuration time.Duration
+}
+
+func (a AWSConfig) isDefault() bool {
+	return len(a.Profile) == 0 && len(a.RoleARN) == 0
+}
+
+// awsSessions shares a session between the AWS clients a Client creates, so
+// that a role is only assumed, and an MFA code only asked for, once.
+type awsSessions struct {
+	mu      sync.Mutex
+	session *session.Session
+}
+
+// awsSession returns the session for the client's credentials.
+func (c *Client) awsSession() (*session.Session, error) {
+	c.sessions.mu.Lock()
+	defer c.sessions.mu.Unlock()
+
+	if c.sessions.session != nil {
+		return c.sessions.session, nil
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile:                 c.AWS.Profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: c.AWS.TokenProvider,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every EC2 client sets its own region, so this is only used for STS.
+	if len(aws.StringValue(sess.Config.Region)) == 0 {
+		sess.Config.Region = aws.String(DefaultAWSRegion)
+	}
+
+	if len(c.AWS.RoleARN) > 0 {
+		sess.Config.Credentials = stscreds.NewCredentials(sess, c.AWS.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = fmt.Sprintf("parsec-ec2-%d", time.Now().Unix())
+
+			p.Duration = c.AWS.SessionDuration
+			if p.Duration == 0 {
+				p.Duration = DefaultAssumeRoleDuration
+			}
+
+			if len(c.AWS.ExternalID) > 0 {
+				p.ExternalID = aws.String(c.AWS.ExternalID)
+			}
+
+			if len(c.AWS.MFASerial) > 0 {
+				p.SerialNumber = aws.String(c.AWS.MFASerial)
+				p.TokenProvider = c.AWS.TokenProvider
+			}
+		})
+	}
+
+	c.sessions.session = sess
+	return sess, nil
+}
+
+// awsEnv is the environment that gives Terraform the same credentials as the
+// client. With the default credential chain Terraform finds them itself, and
+// otherwise they are resolved here, so that a role is assumed and an MFA code
+// asked for by parsec-ec2 rather than by the AWS provider.
+func (c *Client) awsEnv() ([]string, error) {
+	if c.AWS.isDefault() {
+		return nil, nil
+	}
+
+	sess, err := c.awsSession()
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		fmt.Sprintf("AWS_ACCESS_KEY_ID=%s", creds.AccessKeyID),
+		fmt.Sprintf("AWS_SECRET_ACCESS_KEY=%s", creds.SecretAccessKey),
+		fmt.Sprintf("AWS_SESSION_TOKEN=%s", creds.SessionToken),
+
+		// The keys above take precedence over a profile, but a profile that
+		// doesn't exist on this machine would still be an error.
+		"AWS_PROFILE=",
+		"AWS_DEFAULT_PROFILE=",
+	}
+
+	return env, nil
+}
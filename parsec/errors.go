@@ -0,0 +1,180 @@
+package parsec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoSession is returned when there is no current session to act on.
+var ErrNoSession = errors.New("There are no sessions currently running.")
+
+// ErrNoServerKey is returned when a session is started without a Parsec
+// server key.
+var ErrNoServerKey = errors.New("A Parsec server key is needed to start a session.")
+
+// ErrSessionExists is returned when a session is started while another
+// session is running.
+var ErrSessionExists = errors.New("A session is already running. Stop it before starting another.")
+
+// ErrUserDataTooLarge is returned when the provisioning snippets make the
+// user data larger than EC2 accepts.
+type ErrUserDataTooLarge struct {
+	Size     int
+	Snippets []string
+}
+
+func (e *ErrUserDataTooLarge) Error() string {
+	return fmt.Sprintf("The user data would be %d bytes, more than the %d EC2 accepts. Shorten or remove some of the provisioning snippets (%s).",
+		e.Size, MaxUserDataSize, strings.Join(e.Snippets, ", "))
+}
+
+// ErrInvalidSessionName is returned for a session name that can't be used to
+// name the session's files and security group.
+type ErrInvalidSessionName struct {
+	Name string
+}
+
+func (e *ErrInvalidSessionName) Error() string {
+	return fmt.Sprintf("%q is not a valid session name. Session names are up to %d letters, digits, dots, dashes and underscores, starting with a letter or digit.",
+		e.Name, MaxSessionNameLength)
+}
+
+// ErrInvalidRegion is returned for a region id that EC2 doesn't know about.
+type ErrInvalidRegion struct {
+	Region string
+}
+
+func (e *ErrInvalidRegion) Error() string {
+	return fmt.Sprintf("%s is not a valid AWS region id.", e.Region)
+}
+
+// ErrInvalidInstanceType is returned for an instance type that isn't one of GInstances.
+type ErrInvalidInstanceType struct {
+	InstanceType string
+}
+
+func (e *ErrInvalidInstanceType) Error() string {
+	return fmt.Sprintf("%s is not a valid EC2 GPU instance type id.", e.InstanceType)
+}
+
+// ErrNoDefaultVPC is returned when a region has no default VPC and no other
+// VPC has been given.
+type ErrNoDefaultVPC struct {
+	Region string
+}
+
+func (e *ErrNoDefaultVPC) Error() string {
+	return fmt.Sprintf(`There is no default VPC in %s, which can happen if it has been deleted at some point.
+Run the start command again with the --create-vpc flag to have parsec-ec2 create a
+VPC for the session, or with --vpc-id to use an existing VPC.`, e.Region)
+}
+
+// ErrNoSubnetInAZ is returned when the VPC has no subnet in the availability
+// zone with the chosen spot price.
+type ErrNoSubnetInAZ struct {
+	VpcID            string
+	AvailabilityZone string
+}
+
+func (e *ErrNoSubnetInAZ) Error() string {
+	return fmt.Sprintf("Could not get the subnet id for availability zone %s in %s.", e.AvailabilityZone, e.VpcID)
+}
+
+// ErrInstanceTypeUnavailable is returned when there is no spot price history
+// for an instance type, which means it can't be launched in the region.
+type ErrInstanceTypeUnavailable struct {
+	InstanceType string
+	Region       string
+}
+
+func (e *ErrInstanceTypeUnavailable) Error() string {
+	return fmt.Sprintf("%s instances are not yet available in %s.", e.InstanceType, e.Region)
+}
+
+// ErrOverBudget is returned by Start when a session would take the month's
+// spend over a budget with BudgetActionRefuse.
+type ErrOverBudget struct {
+	Check BudgetCheck
+}
+
+func (e *ErrOverBudget) Error() string {
+	return fmt.Sprintf("Starting this session would bring this month's spend to $%.2f, over the $%.2f budget.", e.Check.Projected, e.Check.Budget)
+}
+
+// ErrNoPlan is returned when there is no saved plan with the given id.
+type ErrNoPlan struct {
+	ID string
+}
+
+func (e *ErrNoPlan) Error() string {
+	return fmt.Sprintf("There is no saved plan %s. Plans are removed once they have been applied or are more than %s old.", e.ID, PlanMaxAge)
+}
+
+// ErrInvalidPlanID is returned for a plan id that Plan could not have made,
+// before it is used in a path.
+type ErrInvalidPlanID struct {
+	ID string
+}
+
+func (e *ErrInvalidPlanID) Error() string {
+	return fmt.Sprintf("%q is not a valid plan id. Plan ids are the 8 hex digits printed by 'parsec-ec2 start --plan'.", e.ID)
+}
+
+// ErrStalePlan is returned when a saved plan can no longer be applied as it
+// was reviewed.
+type ErrStalePlan struct {
+	ID     string
+	Reason string
+}
+
+func (e *ErrStalePlan) Error() string {
+	return fmt.Sprintf("Plan %s is out of date because %s. Make a new plan and review it again.", e.ID, e.Reason)
+}
+
+// ErrNotOwner is returned when cleaning up orphans that belong to another
+// user, or that were created by an older version and aren't tagged with a
+// user at all.
+type ErrNotOwner struct {
+	Region string
+	User   string
+}
+
+func (e *ErrNotOwner) Error() string {
+	if len(e.User) == 0 {
+		return fmt.Sprintf("The resources in %s are not tagged with a user, so they are left for you to remove in the AWS console.", e.Region)
+	}
+
+	return fmt.Sprintf("The resources in %s belong to %s and will not be removed.", e.Region, e.User)
+}
+
+// ErrSessionBusy is returned when another process holds the session lock.
+// Lock is nil if the lock was released while it was being read.
+type ErrSessionBusy struct {
+	Lock *Lock
+	Path string
+}
+
+func (e *ErrSessionBusy) Error() string {
+	if e.Lock == nil || e.Lock.PID == 0 {
+		return fmt.Sprintf("The session is busy with another parsec-ec2 command. Try again once it has finished, or remove %s if it was left behind.", e.Path)
+	}
+
+	return fmt.Sprintf("The session is busy: parsec-ec2 has been running %s as process %d on %s since %s. Try again once it has finished, or use --force-unlock if that process is no longer running.",
+		e.Lock.Operation, e.Lock.PID, e.Lock.Host, e.Lock.Created.Local().Format(time.Kitchen))
+}
+
+// ErrTerraform is returned when a Terraform command exits with a non-zero
+// status. Log is where its full output can be found.
+type ErrTerraform struct {
+	Cmd      string
+	ExitCode int
+	Stderr   string
+	Log      string
+}
+
+func (e *ErrTerraform) Error() string {
+	return fmt.Sprintf("Error executing Terraform command: %s (exit status %d)\nError Output: %s\nThe full output has been logged to %s.",
+		e.Cmd, e.ExitCode, e.Stderr, e.Log)
+}
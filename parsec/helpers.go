@@ -0,0 +1,202 @@
+package parsec
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"io/ioutil"
+	"os/exec"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func (c *Client) newEc2Client(region string) (*ec2.EC2, error) {
+	session, err := c.awsSession()
+	if err != nil {
+		return nil, err
+	}
+
+	config := aws.Config{
+		Region: aws.String(region),
+	}
+
+	return ec2.New(session, &config), nil
+}
+
+// getVpcID returns vpcID if it exists in the region, or the region's default
+// VPC if vpcID is empty.
+func getVpcID(ctx context.Context, svc *ec2.EC2, vpcID string) (string, error) {
+	var describeVpcsInput ec2.DescribeVpcsInput
+
+	if len(vpcID) > 0 {
+		describeVpcsInput.VpcIds = []*string{aws.String(vpcID)}
+	} else {
+		describeVpcsInput.Filters = []*ec2.Filter{{
+			Name:   aws.String("isDefault"),
+			Values: []*string{aws.String("true")},
+		}}
+	}
+
+	vpc, err := svc.DescribeVpcsWithContext(ctx, &describeVpcsInput)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(vpc.Vpcs) < 1 {
+		return "", &ErrNoDefaultVPC{Region: aws.StringValue(svc.Config.Region)}
+	}
+
+	return *vpc.Vpcs[0].VpcId, nil
+}
+
+func getSubnetID(ctx context.Context, svc *ec2.EC2, vpcID, availabilityZone string) (string, error) {
+	filters := []*ec2.Filter{
+		{
+			Name:   aws.String("availability-zone"),
+			Values: []*string{&availabilityZone},
+		},
+		{
+			Name:   aws.String("vpc-id"),
+			Values: []*string{&vpcID},
+		},
+	}
+
+	describeSubnetsInput := ec2.DescribeSubnetsInput{
+		Filters: filters,
+	}
+
+	result, err := svc.DescribeSubnetsWithContext(ctx, &describeSubnetsInput)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(result.Subnets) == 0 {
+		return "", &ErrNoSubnetInAZ{VpcID: vpcID, AvailabilityZone: availabilityZone}
+	}
+
+	return *result.Subnets[0].SubnetId, nil
+}
+
+func getAvailabilityZones(ctx context.Context, svc *ec2.EC2) ([]string, error) {
+	result, err := svc.DescribeAvailabilityZonesWithContext(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("state"),
+			Values: []*string{aws.String(ec2.AvailabilityZoneStateAvailable)},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []string
+	for _, zone := range result.AvailabilityZones {
+		zones = append(zones, *zone.ZoneName)
+	}
+
+	return zones, nil
+}
+
+func isAWSErrorCode(err error, code string) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == code
+	}
+	return false
+}
+
+func copyFile(source, destination string) error {
+	b, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(destination, b, 0644)
+}
+
+// tfCmd builds a Terraform command that is interrupted, rather than killed,
+// when ctx is done. Terraform stops cleanly on an interrupt, finishing the
+// operations in flight and saving its state, and is only killed if it takes
+// longer than c.InterruptGrace to do so.
+func (c *Client) tfCmd(ctx context.Context, args []string) (*exec.Cmd, error) {
+	env, err := c.awsEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	command := exec.CommandContext(ctx, Terraform, args...)
+	command.Dir = c.Dir
+	command.Env = append(os.Environ(), env...)
+	command.Cancel = func() error { return interrupt(command.Process) }
+	command.WaitDelay = c.InterruptGrace
+	setProcessGroup(command)
+
+	return command, nil
+}
+
+// tfCmdVars builds a Terraform command for a session, writing the session's
+// variables to its tfvars file first.
+func (c *Client) tfCmdVars(ctx context.Context, p *Session, args []string) (*exec.Cmd, error) {
+	path := c.TfVarsPath(p)
+	if err := c.writeTfVars(p, path); err != nil {
+		return nil, err
+	}
+
+	command, err := c.tfCmd(ctx, append(args, fmt.Sprintf("%s=%s", TfFlagVarFile, path)))
+	if err != nil {
+		return nil, err
+	}
+
+	// The server key and the rendered provisioning snippets, which can hold
+	// secrets too, are only set on a session while its spot request is being
+	// made, and are kept out of the tfvars file.
+	if len(p.ServerKey) > 0 {
+		command.Env = append(command.Env, fmt.Sprintf("TF_VAR_server_key=%s", p.ServerKey))
+	}
+
+	if len(p.Provision) > 0 {
+		command.Env = append(command.Env, fmt.Sprintf("TF_VAR_provision=%s", p.Provision))
+	}
+
+	return command, nil
+}
+
+// terraform runs a Terraform command for a session, logging its output to
+// the session's log.
+func (c *Client) terraform(ctx context.Context, p *Session, args ...string) ([]byte, error) {
+	command, err := c.tfCmdVars(ctx, p, append(args, TfFlagNoColor))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.runSession(ctx, p, command)
+}
+
+// runSession runs a Terraform command built for a session, logging its output
+// to the session's log.
+func (c *Client) runSession(ctx context.Context, p *Session, command *exec.Cmd) ([]byte, error) {
+	output, err := c.run(ctx, command, p.fileName())
+
+	// The state holds the rendered user data, server key included, even when
+	// the command failed part way through.
+	if stateErr := c.protectState(); err == nil {
+		err = stateErr
+	}
+
+	return output, err
+}
+
+// protectState makes the Terraform state files readable only by the user.
+func (c *Client) protectState() error {
+	for _, file := range []string{TfState, TfStateBackup} {
+		err := os.Chmod(fmt.Sprintf("%s/%s", c.Dir, file), 0600)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package parsec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HostSettings are the Parsec host settings written to the instance's
+// config.txt. Zero values leave Parsec's own defaults in place, apart from
+// StartPort, which is DefaultParsecStartPort.
+type HostSettings struct {
+	// StartPort is the first of the ports Parsec listens on. The security
+	// group opens it and the ParsecPortRange ports after it.
+	StartPort int `json:"parsec_start_port,omitempty"`
+
+	// Bitrate is the encoder bitrate in Mbps.
+	Bitrate int `json:"parsec_bitrate,omitempty"`
+
+	// Resolution is the host's resolution, such as 1920x1080.
+	Resolution string `json:"parsec_resolution,omitempty"`
+
+	// HostName is the name the host is shown under in the Parsec app.
+	HostName string `json:"parsec_host_name,omitempty"`
+}
+
+var resolutionPattern = regexp.MustCompile(`^(\d+)x(\d+)$`)
+
+func (h HostSettings) withDefaults() HostSettings {
+	if h.StartPort == 0 {
+		h.StartPort = DefaultParsecStartPort
+	}
+
+	return h
+}
+
+// EndPort is the last port Parsec listens on, which is passed to parsec.tf as
+// parsec_end_port for the security group.
+func (h HostSettings) EndPort() int {
+	return h.withDefaults().StartPort + ParsecPortRange
+}
+
+// Validate checks the settings before they are written to config.txt.
+func (h HostSettings) Validate() error {
+	h = h.withDefaults()
+
+	if h.StartPort < MinParsecStartPort || h.StartPort > MaxPort-ParsecPortRange {
+		return fmt.Errorf("The Parsec start port must be between %d and %d, not %d.", MinParsecStartPort, MaxPort-ParsecPortRange, h.StartPort)
+	}
+
+	if h.Bitrate < 0 || h.Bitrate > MaxParsecBitrate {
+		return fmt.Errorf("The Parsec bitrate must be between 0 and %d Mbps (0 for Parsec's default), not %d Mbps.", MaxParsecBitrate, h.Bitrate)
+	}
+
+	if len(h.Resolution) > 0 {
+		if _, _, err := h.resolution(); err != nil {
+			return err
+		}
+	}
+
+	// config.txt is written from a PowerShell string that expands $ and `.
+	if len(h.HostName) > MaxParsecHostName || strings.ContainsAny(h.HostName, "=$`\"\r\n") {
+		return fmt.Errorf("The Parsec host name must be at most %d characters on one line, without =, $, ` or \".", MaxParsecHostName)
+	}
+
+	return nil
+}
+
+func (h HostSettings) resolution() (int, int, error) {
+	match := resolutionPattern.FindStringSubmatch(h.Resolution)
+	if match == nil {
+		return 0, 0, fmt.Errorf("The Parsec resolution must be given as <width>x<height>, such as 1920x1080, not %q.", h.Resolution)
+	}
+
+	width, _ := strconv.Atoi(match[1])
+	height, _ := strconv.Atoi(match[2])
+
+	if width < MinParsecWidth || height < MinParsecHeight || width > MaxParsecWidth || height > MaxParsecHeight {
+		return 0, 0, fmt.Errorf("The Parsec resolution must be between %dx%d and %dx%d, not %s.",
+			MinParsecWidth, MinParsecHeight, MaxParsecWidth, MaxParsecHeight, h.Resolution)
+	}
+
+	return width, height, nil
+}
+
+// ConfigLines are the config.txt lines for the settings that are set, other
+// than the start port, which user_data.tmpl writes itself.
+func (h HostSettings) ConfigLines() string {
+	var lines []string
+
+	if h.Bitrate > 0 {
+		lines = append(lines, fmt.Sprintf("encoder_bitrate=%d", h.Bitrate))
+	}
+
+	if width, height, err := h.resolution(); err == nil {
+		lines = append(lines, fmt.Sprintf("server_resolution_x=%d", width), fmt.Sprintf("server_resolution_y=%d", height))
+	}
+
+	if len(h.HostName) > 0 {
+		lines = append(lines, fmt.Sprintf("host_name=%s", h.HostName))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,84 @@
+package parsec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		host HostSettings
+		err  string
+	}{
+		{name: "defaults", host: HostSettings{}},
+		{name: "everything", host: HostSettings{StartPort: 9000, Bitrate: MaxParsecBitrate, Resolution: "2560x1440", HostName: "ec2 gaming"}},
+		{name: "lowest start port", host: HostSettings{StartPort: MinParsecStartPort}},
+		{name: "highest start port", host: HostSettings{StartPort: MaxPort - ParsecPortRange}},
+		{name: "start port too low", host: HostSettings{StartPort: MinParsecStartPort - 1}, err: "start port"},
+		{name: "start port too high", host: HostSettings{StartPort: MaxPort - ParsecPortRange + 1}, err: "start port"},
+		{name: "bitrate too high", host: HostSettings{Bitrate: MaxParsecBitrate + 1}, err: "between 0 and"},
+		{name: "negative bitrate", host: HostSettings{Bitrate: -1}, err: "between 0 and"},
+		{name: "resolution not WxH", host: HostSettings{Resolution: "1080p"}, err: "<width>x<height>"},
+		{name: "resolution too small", host: HostSettings{Resolution: "320x200"}, err: "must be between"},
+		{name: "resolution too large", host: HostSettings{Resolution: "15360x8640"}, err: "must be between"},
+		{name: "host name too long", host: HostSettings{HostName: strings.Repeat("a", MaxParsecHostName+1)}, err: "host name"},
+		{name: "host name with a newline", host: HostSettings{HostName: "a\nserver_key=x"}, err: "host name"},
+		{name: "host name with =", host: HostSettings{HostName: "a=b"}, err: "host name"},
+		{name: "host name with $", host: HostSettings{HostName: "$env:USERNAME"}, err: "host name"},
+		{name: "host name with a backtick", host: HostSettings{HostName: "a`nb"}, err: "host name"},
+		{name: "host name with a quote", host: HostSettings{HostName: `a"b`}, err: "host name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.host.Validate()
+			if len(tt.err) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Validate() succeeded, want an error about %s", tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate() error = %q, want it to mention %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestHostSettingsConfigLines(t *testing.T) {
+	tests := []struct {
+		name string
+		host HostSettings
+		want string
+	}{
+		{name: "defaults", host: HostSettings{}, want: ""},
+		{name: "start port is left to user_data.tmpl", host: HostSettings{StartPort: 9000}, want: ""},
+		{name: "bitrate", host: HostSettings{Bitrate: 20}, want: "encoder_bitrate=20"},
+		{name: "resolution", host: HostSettings{Resolution: "1920x1080"}, want: "server_resolution_x=1920\nserver_resolution_y=1080"},
+		{name: "invalid resolution is left out", host: HostSettings{Resolution: "1080p"}, want: ""},
+		{name: "everything", host: HostSettings{Bitrate: 30, Resolution: "2560x1440", HostName: "ec2 gaming"}, want: "encoder_bitrate=30\nserver_resolution_x=2560\nserver_resolution_y=1440\nhost_name=ec2 gaming"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.host.ConfigLines(); got != tt.want {
+				t.Errorf("ConfigLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostSettingsEndPort(t *testing.T) {
+	if got, want := (HostSettings{}).EndPort(), DefaultParsecStartPort+ParsecPortRange; got != want {
+		t.Errorf("EndPort() of the defaults = %d, want %d", got, want)
+	}
+
+	if got, want := (HostSettings{StartPort: 9000}).EndPort(), 9000+ParsecPortRange; got != want {
+		t.Errorf("EndPort() = %d, want %d", got, want)
+	}
+}
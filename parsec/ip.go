@@ -0,0 +1,254 @@
+package parsec
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IPResolver looks up the public IPv4 address of the machine running parsec-ec2.
+type IPResolver interface {
+	ResolveIP(ctx context.Context) (net.IP, error)
+}
+
+// StaticResolver returns an address supplied by the user with the --ip flag.
+type StaticResolver struct {
+	IP string
+}
+
+func (r StaticResolver) ResolveIP(ctx context.Context) (net.IP, error) {
+	return validateIP(strings.TrimSuffix(strings.TrimSpace(r.IP), "/32"))
+}
+
+// maxIPResponseSize is the longest response accepted from an HTTP provider.
+const maxIPResponseSize = 64
+
+// HTTPResolver asks a plain text "what is my ip" service such as icanhazip.
+type HTTPResolver struct {
+	URL    string
+	Client *http.Client
+}
+
+func (r HTTPResolver) ResolveIP(ctx context.Context) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", r.URL, resp.StatusCode)
+	}
+
+	// A bare IPv4 address with a trailing newline is never more than 16 bytes,
+	// anything much longer is not the response we are looking for.
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIPResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > maxIPResponseSize {
+		return nil, fmt.Errorf("%s returned more than %d bytes", r.URL, maxIPResponseSize)
+	}
+
+	return validateIP(strings.TrimSpace(string(b)))
+}
+
+// STUNResolver sends a STUN binding request (RFC 5389) over UDP and reads the
+// mapped address from the response.
+type STUNResolver struct {
+	Server  string
+	Timeout time.Duration
+}
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunHeaderLength    = 20
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+)
+
+func (r STUNResolver) ResolveIP(ctx context.Context) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", r.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// Reading from UDP doesn't take a context, so closing the connection is
+	// what interrupts it when the context is cancelled.
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	request := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(request[2:4], 0)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 512)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := parseSTUNResponse(response[:n], request[8:20])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", r.Server, err)
+	}
+
+	return validateIP(ip.String())
+}
+
+func parseSTUNResponse(b, transactionID []byte) (net.IP, error) {
+	if len(b) < stunHeaderLength {
+		return nil, errors.New("STUN response is too short")
+	}
+
+	if binary.BigEndian.Uint16(b[0:2]) != stunBindingResponse {
+		return nil, errors.New("STUN response is not a binding success response")
+	}
+
+	if binary.BigEndian.Uint32(b[4:8]) != stunMagicCookie || string(b[8:20]) != string(transactionID) {
+		return nil, errors.New("STUN response does not match the request")
+	}
+
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if stunHeaderLength+length > len(b) {
+		return nil, errors.New("STUN response is truncated")
+	}
+
+	var mapped net.IP
+	attrs := b[stunHeaderLength : stunHeaderLength+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLength > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLength]
+
+		// Only IPv4 (family 0x01) addresses are of any use for the security group.
+		if attrLength >= 8 && value[1] == 0x01 {
+			switch attrType {
+			case stunAttrXorMappedAddress:
+				ip := make(net.IP, net.IPv4len)
+				binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(value[4:8])^stunMagicCookie)
+				return ip, nil
+			case stunAttrMappedAddress:
+				mapped = net.IPv4(value[4], value[5], value[6], value[7]).To4()
+			}
+		}
+
+		// Attributes are padded to a multiple of four bytes.
+		next := 4 + (attrLength+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped != nil {
+		return mapped, nil
+	}
+
+	return nil, errors.New("STUN response did not contain an IPv4 mapped address")
+}
+
+// ChainResolver tries each resolver in order and returns the first valid address.
+type ChainResolver []IPResolver
+
+func (c ChainResolver) ResolveIP(ctx context.Context) (net.IP, error) {
+	var failures []string
+
+	for _, r := range c {
+		ip, err := r.ResolveIP(ctx)
+		if err == nil {
+			return ip, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		failures = append(failures, err.Error())
+	}
+
+	if len(failures) == 0 {
+		return nil, errors.New("No external ip address providers are configured.")
+	}
+
+	return nil, fmt.Errorf("Could not get external ip address:\n  %s", strings.Join(failures, "\n  "))
+}
+
+// validateIP only accepts a public IPv4 unicast address, since anything else
+// would produce a security group rule that lets nobody (or everybody) in.
+func validateIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an ip address", s)
+	}
+
+	ip = ip.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not an IPv4 address", s)
+	}
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return nil, fmt.Errorf("%s is not a public unicast address", s)
+	}
+
+	return ip, nil
+}
+
+// NewIPResolver returns a StaticResolver for override if it is set, otherwise
+// a ChainResolver trying each HTTP provider and then each STUN server.
+func NewIPResolver(override string, providers, stunServers []string, timeout time.Duration) IPResolver {
+	if len(override) > 0 {
+		return StaticResolver{IP: override}
+	}
+
+	client := &http.Client{Timeout: timeout}
+
+	var chain ChainResolver
+	for _, url := range providers {
+		chain = append(chain, HTTPResolver{URL: url, Client: client})
+	}
+
+	for _, server := range stunServers {
+		chain = append(chain, STUNResolver{Server: server, Timeout: timeout})
+	}
+
+	return chain
+}
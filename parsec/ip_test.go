@@ -0,0 +1,210 @@
+package parsec
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPResolver(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+		errMsg string
+	}{
+		{name: "valid", status: http.StatusOK, body: "203.0.113.7\n", want: "203.0.113.7"},
+		{name: "non-200 status", status: http.StatusServiceUnavailable, body: "203.0.113.7\n", errMsg: "returned status 503"},
+		{name: "body over 64 bytes", status: http.StatusOK, body: "203.0.113.7" + strings.Repeat(" ", 60), errMsg: "more than 64 bytes"},
+		{name: "not an ip", status: http.StatusOK, body: "<html>hello</html>", errMsg: "is not an ip address"},
+		{name: "private address", status: http.StatusOK, body: "192.168.1.10", errMsg: "not a public unicast address"},
+		{name: "IPv6 address", status: http.StatusOK, body: "2001:db8::1", errMsg: "not an IPv4 address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			ip, err := HTTPResolver{URL: server.URL, Client: server.Client()}.ResolveIP(context.Background())
+			if len(tt.errMsg) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("ResolveIP() error = %v, want it to contain %q", err, tt.errMsg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ResolveIP() error = %v", err)
+			}
+			if ip.String() != tt.want {
+				t.Errorf("ResolveIP() = %s, want %s", ip, tt.want)
+			}
+		})
+	}
+}
+
+// stunResponse builds a binding success response carrying attrs.
+func stunResponse(cookie uint32, transactionID []byte, attrs []byte) []byte {
+	b := make([]byte, stunHeaderLength, stunHeaderLength+len(attrs))
+	binary.BigEndian.PutUint16(b[0:2], stunBindingResponse)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(attrs)))
+	binary.BigEndian.PutUint32(b[4:8], cookie)
+	copy(b[8:20], transactionID)
+	return append(b, attrs...)
+}
+
+// stunAddress builds an IPv4 address attribute, XORed with the magic cookie
+// for XOR-MAPPED-ADDRESS.
+func stunAddress(attrType uint16, ip net.IP) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:2], attrType)
+	binary.BigEndian.PutUint16(b[2:4], 8)
+	b[5] = 0x01
+	address := binary.BigEndian.Uint32(ip.To4())
+	if attrType == stunAttrXorMappedAddress {
+		address ^= stunMagicCookie
+	}
+	binary.BigEndian.PutUint32(b[8:12], address)
+	return b
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	transactionID := []byte("0123456789ab")
+	ip := net.ParseIP("203.0.113.7")
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     string
+		errMsg   string
+	}{
+		{
+			name:     "XOR-MAPPED-ADDRESS",
+			response: stunResponse(stunMagicCookie, transactionID, stunAddress(stunAttrXorMappedAddress, ip)),
+			want:     "203.0.113.7",
+		},
+		{
+			name: "XOR-MAPPED-ADDRESS preferred over MAPPED-ADDRESS",
+			response: stunResponse(stunMagicCookie, transactionID, append(
+				stunAddress(stunAttrMappedAddress, net.ParseIP("198.51.100.1")),
+				stunAddress(stunAttrXorMappedAddress, ip)...)),
+			want: "203.0.113.7",
+		},
+		{
+			name:     "bad magic cookie",
+			response: stunResponse(0xdeadbeef, transactionID, stunAddress(stunAttrXorMappedAddress, ip)),
+			errMsg:   "does not match the request",
+		},
+		{
+			name:     "wrong transaction id",
+			response: stunResponse(stunMagicCookie, []byte("ba9876543210"), stunAddress(stunAttrXorMappedAddress, ip)),
+			errMsg:   "does not match the request",
+		},
+		{
+			name:     "truncated attribute",
+			response: stunResponse(stunMagicCookie, transactionID, stunAddress(stunAttrXorMappedAddress, ip)[:8]),
+			errMsg:   "did not contain an IPv4 mapped address",
+		},
+		{
+			name:     "truncated message",
+			response: stunResponse(stunMagicCookie, transactionID, stunAddress(stunAttrXorMappedAddress, ip))[:24],
+			errMsg:   "truncated",
+		},
+		{
+			name:     "too short",
+			response: []byte{0x01, 0x01},
+			errMsg:   "too short",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSTUNResponse(tt.response, transactionID)
+			if len(tt.errMsg) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("parseSTUNResponse() error = %v, want it to contain %q", err, tt.errMsg)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseSTUNResponse() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseSTUNResponse() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeResolver struct {
+	ip    string
+	err   error
+	calls *int
+}
+
+func (r fakeResolver) ResolveIP(ctx context.Context) (net.IP, error) {
+	if r.calls != nil {
+		*r.calls++
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return net.ParseIP(r.ip).To4(), nil
+}
+
+func TestChainResolver(t *testing.T) {
+	t.Run("falls back to the next resolver", func(t *testing.T) {
+		var calls int
+		chain := ChainResolver{
+			fakeResolver{err: errors.New("first failed")},
+			fakeResolver{ip: "203.0.113.7"},
+			fakeResolver{ip: "198.51.100.1", calls: &calls},
+		}
+
+		ip, err := chain.ResolveIP(context.Background())
+		if err != nil {
+			t.Fatalf("ResolveIP() error = %v", err)
+		}
+		if ip.String() != "203.0.113.7" {
+			t.Errorf("ResolveIP() = %s, want 203.0.113.7", ip)
+		}
+		if calls != 0 {
+			t.Errorf("resolvers after the first success were called %d times", calls)
+		}
+	})
+
+	t.Run("combines the errors when all fail", func(t *testing.T) {
+		chain := ChainResolver{
+			fakeResolver{err: errors.New("first failed")},
+			fakeResolver{err: errors.New("second failed")},
+		}
+
+		_, err := chain.ResolveIP(context.Background())
+		if err == nil {
+			t.Fatal("ResolveIP() succeeded, want an error")
+		}
+		for _, msg := range []string{"first failed", "second failed"} {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("ResolveIP() error = %q, want it to contain %q", err, msg)
+			}
+		}
+	})
+
+	t.Run("no resolvers", func(t *testing.T) {
+		if _, err := (ChainResolver{}).ResolveIP(context.Background()); err == nil {
+			t.Fatal("ResolveIP() succeeded, want an error")
+		}
+	})
+}
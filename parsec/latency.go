@@ -0,0 +1,461 @@
+package parsec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// Latency Methods
+const (
+	LatencyMethodTCP   = "tcp"
+	LatencyMethodHTTPS = "https"
+)
+
+// Latency Probe Defaults
+const (
+	DefaultLatencyTimeout  = 2 * time.Second
+	DefaultLatencyProbes   = 3
+	DefaultLatencyCacheAge = 6 * time.Hour
+	DefaultLatencyWeight   = 0.5
+)
+
+// LatencyOptions selects how the latency to each region is measured.
+type LatencyOptions struct {
+	// Method is LatencyMethodTCP, which times the TCP handshake, or
+	// LatencyMethodHTTPS, which times HTTPS requests made over a connection
+	// that has already been set up. TCP is used if it is empty.
+	Method string
+
+	// Probes is how many times each region is measured, keeping the quickest.
+	Probes int
+
+	// Timeout is how long each probe waits for a region to answer.
+	Timeout time.Duration
+}
+
+func (o LatencyOptions) withDefaults() LatencyOptions {
+	if len(o.Method) == 0 {
+		o.Method = LatencyMethodTCP
+	}
+	if o.Probes <= 0 {
+		o.Probes = DefaultLatencyProbes
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultLatencyTimeout
+	}
+	return o
+}
+
+// RegionLatency is the round trip time to a region's EC2 endpoint. Err is set
+// if the endpoint couldn't be reached.
+type RegionLatency struct {
+	Region  string
+	Latency time.Duration
+	Err     error
+}
+
+// MeasureLatency measures the round trip time to the EC2 endpoint of every
+// region concurrently, and returns the regions sorted from nearest to
+// furthest with unreachable regions last. The EC2 endpoint is a stand-in for
+// an instance in the region, which is what a Parsec client connects to.
+func MeasureLatency(ctx context.Context, regions []string, opts LatencyOptions) ([]RegionLatency, error) {
+	opts = opts.withDefaults()
+
+	if opts.Method != LatencyMethodTCP && opts.Method != LatencyMethodHTTPS {
+		return nil, fmt.Errorf("The latency method must be either %s or %s, not %q.", LatencyMethodTCP, LatencyMethodHTTPS, opts.Method)
+	}
+
+	results := make([]RegionLatency, len(regions))
+
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+			results[i] = measureRegion(ctx, region, opts)
+		}(i, region)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sortLatencies(results)
+	return results, nil
+}
+
+func sortLatencies(results []RegionLatency) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Err == nil) != (results[j].Err == nil) {
+			return results[i].Err == nil
+		}
+		return results[i].Latency < results[j].Latency
+	})
+}
+
+func measureRegion(ctx context.Context, region string, opts LatencyOptions) RegionLatency {
+	r := RegionLatency{Region: region}
+
+	endpoint, err := endpoints.DefaultResolver().EndpointFor(endpoints.Ec2ServiceID, region)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	probe := tcpProbe
+	if opts.Method == LatencyMethodHTTPS {
+		probe = newHTTPSProbe(opts.Timeout)
+	}
+
+	for i := 0; i < opts.Probes; i++ {
+		elapsed, err := probe(ctx, endpoint.URL, opts.Timeout)
+		if err != nil {
+			r.Err = err
+			continue
+		}
+
+		if r.Latency == 0 || elapsed < r.Latency {
+			r.Latency = elapsed
+		}
+	}
+
+	// One successful probe is enough.
+	if r.Latency > 0 {
+		r.Err = nil
+	}
+
+	return r
+}
+
+// tcpProbe times the TCP handshake with an endpoint, which takes one round
+// trip.
+func tcpProbe(ctx context.Context, endpoint string, timeout time.Duration) (time.Duration, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), "443"))
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+
+	return elapsed, conn.Close()
+}
+
+// newHTTPSProbe returns a probe that times HTTPS requests to an endpoint. The
+// connection and TLS handshake are set up by the first request, which isn't
+// counted, so later requests take one round trip plus the time AWS takes to
+// answer. This gets through networks that only allow traffic through a proxy.
+func newHTTPSProbe(timeout time.Duration) func(context.Context, string, time.Duration) (time.Duration, error) {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: 1,
+		},
+	}
+
+	warm := false
+
+	request := func(ctx context.Context, endpoint string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+
+	return func(ctx context.Context, endpoint string, _ time.Duration) (time.Duration, error) {
+		if !warm {
+			if err := request(ctx, endpoint); err != nil {
+				return 0, err
+			}
+			warm = true
+		}
+
+		start := time.Now()
+		if err := request(ctx, endpoint); err != nil {
+			return 0, err
+		}
+
+		return time.Since(start), nil
+	}
+}
+
+// latencyCache is the last measurement, saved so that regions can be ranked
+// without measuring them every time.
+type latencyCache struct {
+	Measured time.Time            `json:"measured"`
+	Method   string               `json:"method"`
+	Regions  []latencyCacheRegion `json:"regions"`
+}
+
+type latencyCacheRegion struct {
+	Region    string  `json:"region"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Latency returns the latency to every region, measuring it if there is no
+// measurement of the current regions made with opts.Method in the last
+// maxAge, or if refresh is set. It also returns when the latencies were
+// measured.
+func (c *Client) Latency(ctx context.Context, opts LatencyOptions, maxAge time.Duration, refresh bool) ([]RegionLatency, time.Time, error) {
+	opts = opts.withDefaults()
+	path := fmt.Sprintf("%s/%s", c.Dir, LatencyCache)
+
+	regions := RegionIDs()
+
+	if !refresh {
+		if cache, err := readLatencyCache(path); err == nil && cache.fresh(opts.Method, regions, maxAge) {
+			return cache.latencies(), cache.Measured, nil
+		}
+	}
+
+	latencies, err := MeasureLatency(ctx, regions, opts)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cache := newLatencyCache(latencies, opts.Method, time.Now().UTC())
+
+	// A measurement made while offline is not worth keeping.
+	if len(latencies) == 0 || latencies[0].Err != nil {
+		return latencies, cache.Measured, nil
+	}
+
+	return latencies, cache.Measured, writeLatencyCache(path, cache)
+}
+
+// fresh reports whether the cache can be used instead of measuring: it was
+// measured with method in the last maxAge, and holds exactly regions, which
+// change when the SDK learns about new ones.
+func (cache *latencyCache) fresh(method string, regions []string, maxAge time.Duration) bool {
+	if cache.Method != method || time.Since(cache.Measured) >= maxAge || len(cache.Regions) != len(regions) {
+		return false
+	}
+
+	cached := map[string]bool{}
+	for _, entry := range cache.Regions {
+		cached[entry.Region] = true
+	}
+
+	for _, region := range regions {
+		if !cached[region] {
+			return false
+		}
+	}
+
+	return len(cached) == len(regions)
+}
+
+func readLatencyCache(path string) (*latencyCache, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache latencyCache
+	if err := json.Unmarshal(bytes, &cache); err != nil {
+		return nil, err
+	}
+
+	return &cache, nil
+}
+
+// writeLatencyCache saves the cache readable only by the user, since the
+// latencies hint at where they are.
+func writeLatencyCache(path string, cache latencyCache) error {
+	bytes, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, bytes, 0600); err != nil {
+		return err
+	}
+
+	// WriteFile only applies the mode to new files.
+	return os.Chmod(path, 0600)
+}
+
+func newLatencyCache(latencies []RegionLatency, method string, measured time.Time) latencyCache {
+	cache := latencyCache{Measured: measured, Method: method}
+
+	for _, l := range latencies {
+		entry := latencyCacheRegion{Region: l.Region}
+		if l.Err != nil {
+			entry.Error = l.Err.Error()
+		} else {
+			entry.LatencyMs = float64(l.Latency) / float64(time.Millisecond)
+		}
+		cache.Regions = append(cache.Regions, entry)
+	}
+
+	return cache
+}
+
+func (cache *latencyCache) latencies() []RegionLatency {
+	var latencies []RegionLatency
+
+	for _, entry := range cache.Regions {
+		l := RegionLatency{Region: entry.Region}
+		if len(entry.Error) > 0 {
+			l.Err = errors.New(entry.Error)
+		} else {
+			l.Latency = time.Duration(entry.LatencyMs * float64(time.Millisecond))
+		}
+		latencies = append(latencies, l)
+	}
+
+	sortLatencies(latencies)
+	return latencies
+}
+
+// RegionScore ranks a region for an instance type by its latency and the
+// current spot price there. Lower scores are better.
+type RegionScore struct {
+	Region  string
+	Latency time.Duration
+	Price   float64
+	Score   float64
+}
+
+// RankRegions looks up the current spot price of an instance type in every
+// reachable region concurrently and ranks the regions by a score combining
+// latency and price. Each is scaled between the best and worst region, and
+// latencyWeight, between 0 and 1, is how much latency counts against price.
+// Regions where the instance type isn't available are left out, and regions
+// whose price can't be looked up, such as those not enabled for the account,
+// are reported in the returned warnings.
+func (c *Client) RankRegions(ctx context.Context, instanceType string, latencies []RegionLatency, latencyWeight float64) ([]RegionScore, []error, error) {
+	if !IsValidGInstance(GInstances(), instanceType) {
+		return nil, nil, &ErrInvalidInstanceType{InstanceType: instanceType}
+	}
+
+	if latencyWeight < 0 || latencyWeight > 1 {
+		return nil, nil, fmt.Errorf("The latency weight must be between 0 and 1, not %g.", latencyWeight)
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		scores []RegionScore
+		errs   []error
+	)
+
+	for _, l := range latencies {
+		if l.Err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(l RegionLatency) {
+			defer wg.Done()
+
+			spotPrice, err := c.Price(ctx, PriceOptions{Region: l.Region, InstanceType: instanceType})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			var unavailable *ErrInstanceTypeUnavailable
+			if errors.As(err, &unavailable) {
+				return
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", l.Region, err))
+				return
+			}
+
+			price, err := strconv.ParseFloat(aws.StringValue(spotPrice.SpotPrice), 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", l.Region, err))
+				return
+			}
+
+			scores = append(scores, RegionScore{Region: l.Region, Latency: l.Latency, Price: price})
+		}(l)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	scoreRegions(scores, latencyWeight)
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+
+	return scores, errs, nil
+}
+
+// scoreRegions scores and sorts regions, best first.
+func scoreRegions(scores []RegionScore, latencyWeight float64) {
+	if len(scores) == 0 {
+		return
+	}
+
+	minLatency, maxLatency := scores[0].Latency, scores[0].Latency
+	minPrice, maxPrice := scores[0].Price, scores[0].Price
+	for _, s := range scores {
+		if s.Latency < minLatency {
+			minLatency = s.Latency
+		}
+		if s.Latency > maxLatency {
+			maxLatency = s.Latency
+		}
+		if s.Price < minPrice {
+			minPrice = s.Price
+		}
+		if s.Price > maxPrice {
+			maxPrice = s.Price
+		}
+	}
+
+	scale := func(value, min, max float64) float64 {
+		if max == min {
+			return 0
+		}
+		return (value - min) / (max - min)
+	}
+
+	for i := range scores {
+		latency := scale(float64(scores[i].Latency), float64(minLatency), float64(maxLatency))
+		price := scale(scores[i].Price, minPrice, maxPrice)
+		scores[i].Score = latencyWeight*latency + (1-latencyWeight)*price
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score < scores[j].Score
+		}
+		return scores[i].Latency < scores[j].Latency
+	})
+}
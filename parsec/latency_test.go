@@ -0,0 +1,105 @@
+package parsec
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLatencyCacheFresh(t *testing.T) {
+	regions := []string{"eu-west-1", "us-east-1"}
+	measured := time.Now().Add(-time.Hour)
+
+	cache := newLatencyCache([]RegionLatency{
+		{Region: "eu-west-1", Latency: 20 * time.Millisecond},
+		{Region: "us-east-1", Err: errors.New("timed out")},
+	}, LatencyMethodTCP, measured)
+
+	tests := []struct {
+		name    string
+		method  string
+		regions []string
+		maxAge  time.Duration
+		want    bool
+	}{
+		{name: "fresh", method: LatencyMethodTCP, regions: regions, maxAge: 6 * time.Hour, want: true},
+		{name: "in another order", method: LatencyMethodTCP, regions: []string{"us-east-1", "eu-west-1"}, maxAge: 6 * time.Hour, want: true},
+		{name: "too old", method: LatencyMethodTCP, regions: regions, maxAge: 30 * time.Minute},
+		{name: "another method", method: LatencyMethodHTTPS, regions: regions, maxAge: 6 * time.Hour},
+		{name: "a new region", method: LatencyMethodTCP, regions: append(regions, "eu-south-1"), maxAge: 6 * time.Hour},
+		{name: "a region gone", method: LatencyMethodTCP, regions: regions[:1], maxAge: 6 * time.Hour},
+		{name: "a region replaced", method: LatencyMethodTCP, regions: []string{"eu-west-1", "eu-south-1"}, maxAge: 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cache.fresh(tt.method, tt.regions, tt.maxAge); got != tt.want {
+				t.Errorf("fresh() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("duplicated region", func(t *testing.T) {
+		duplicated := newLatencyCache([]RegionLatency{{Region: "eu-west-1"}, {Region: "eu-west-1"}}, LatencyMethodTCP, measured)
+		if duplicated.fresh(LatencyMethodTCP, regions, 6*time.Hour) {
+			t.Error("fresh() = true for a cache missing a region")
+		}
+	})
+}
+
+func TestLatencyCacheRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := fmt.Sprintf("%s/%s", dir, LatencyCache)
+
+	// Caches used to be readable by everyone.
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	measured := time.Date(2020, 1, 6, 18, 30, 0, 0, time.UTC)
+	cache := newLatencyCache([]RegionLatency{
+		{Region: "us-east-1", Latency: 90 * time.Millisecond},
+		{Region: "eu-west-1", Latency: 20 * time.Millisecond},
+		{Region: "ap-east-1", Err: errors.New("timed out")},
+	}, LatencyMethodTCP, measured)
+
+	if err := writeLatencyCache(path, cache); err != nil {
+		t.Fatalf("writeLatencyCache() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode = %o, want 600", mode)
+	}
+
+	read, err := readLatencyCache(path)
+	if err != nil {
+		t.Fatalf("readLatencyCache() error = %v", err)
+	}
+	if !read.Measured.Equal(measured) || read.Method != LatencyMethodTCP {
+		t.Errorf("readLatencyCache() = %s with %s, want %s with %s", read.Measured, read.Method, measured, LatencyMethodTCP)
+	}
+
+	latencies := read.latencies()
+	var order []string
+	for _, l := range latencies {
+		order = append(order, l.Region)
+	}
+	if fmt.Sprint(order) != "[eu-west-1 us-east-1 ap-east-1]" {
+		t.Errorf("latencies() in order %v, want the quickest first and failures last", order)
+	}
+	if latencies[0].Latency != 20*time.Millisecond || latencies[2].Err == nil {
+		t.Errorf("latencies() = %+v", latencies)
+	}
+}
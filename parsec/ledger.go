@@ -0,0 +1,165 @@
+package parsec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// Ledger Events
+const (
+	LedgerEventStart = "start"
+	LedgerEventStop  = "stop"
+)
+
+// LedgerEntry is a line in the spend ledger. A start entry is appended when a
+// spot request is made and a stop entry holding the final bill is appended
+// when the session is stopped.
+type LedgerEntry struct {
+	Event            string    `json:"event"`
+	User             string    `json:"user"`
+	Region           string    `json:"region"`
+	AvailabilityZone string    `json:"availability_zone"`
+	InstanceType     string    `json:"instance_type"`
+	SpotPrice        string    `json:"spot_price"`
+	LaunchTime       time.Time `json:"launch_time"`
+	StopTime         time.Time `json:"stop_time,omitempty"`
+	InstanceCost     float64   `json:"instance_cost,omitempty"`
+	StorageCost      float64   `json:"storage_cost,omitempty"`
+	TotalCost        float64   `json:"total_cost,omitempty"`
+
+	// Estimated is set on a stop entry billed at the bid price because the
+	// actual cost couldn't be calculated, and Error says why.
+	Estimated bool   `json:"estimated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newStartLedgerEntry(p *Session) LedgerEntry {
+	return LedgerEntry{
+		Event:            LedgerEventStart,
+		User:             p.User,
+		Region:           p.Region,
+		AvailabilityZone: p.AvailabilityZone,
+		InstanceType:     p.InstanceType,
+		SpotPrice:        p.SpotPrice,
+		LaunchTime:       p.LaunchTime,
+	}
+}
+
+func newLedgerEntry(p *Session, cost SessionCost) LedgerEntry {
+	return LedgerEntry{
+		Event:            LedgerEventStop,
+		User:             p.User,
+		Region:           p.Region,
+		AvailabilityZone: p.AvailabilityZone,
+		InstanceType:     p.InstanceType,
+		SpotPrice:        p.SpotPrice,
+		LaunchTime:       cost.Start,
+		StopTime:         cost.End,
+		InstanceCost:     cost.InstanceCost,
+		StorageCost:      cost.StorageCost,
+		TotalCost:        cost.Total(),
+		Estimated:        cost.Estimated,
+	}
+}
+
+// CurrentUser is the local login name, which sessions are recorded under
+// when StartOptions.User is empty.
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return "unknown"
+}
+
+func (c *Client) appendLedger(entry LedgerEntry) error {
+	return c.appendJSONLine(Ledger, entry)
+}
+
+// appendJSONLine appends v as a single line of JSON to a file in the client's
+// directory, creating the file if needed.
+func (c *Client) appendJSONLine(filename string, v interface{}) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	filePath := fmt.Sprintf("%s/%s", c.Dir, filename)
+
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(bytes, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Ledger reads every entry in the spend ledger, oldest first.
+func (c *Client) Ledger() ([]LedgerEntry, error) {
+	filePath := fmt.Sprintf("%s/%s", c.Dir, Ledger)
+
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", filePath, line, err)
+		}
+
+		// Entries written before start events were recorded only held final bills.
+		if len(entry.Event) == 0 {
+			entry.Event = LedgerEventStop
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// MonthlySpend is the total of the final bills for sessions stopped by user
+// in the same calendar month as t.
+func MonthlySpend(entries []LedgerEntry, user string, t time.Time) float64 {
+	var total float64
+
+	for _, entry := range entries {
+		if entry.Event != LedgerEventStop || entry.User != user {
+			continue
+		}
+
+		if SameMonth(entry.StopTime, t) {
+			total += entry.TotalCost
+		}
+	}
+
+	return total
+}
+
+// SameMonth reports whether a and b fall in the same UTC calendar month.
+func SameMonth(a, b time.Time) bool {
+	a, b = a.UTC(), b.UTC()
+	return a.Year() == b.Year() && a.Month() == b.Month()
+}
@@ -0,0 +1,209 @@
+package parsec
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// StaleLockAge is how long a session lock is honoured for. No operation holds
+// the lock for anywhere near this long, so an older lock was left behind by a
+// process that was killed, possibly on another machine sharing the directory.
+const StaleLockAge = 2 * time.Hour
+
+// LockAttempts is how many times taking the session lock is tried while it is
+// being released or replaced by other processes.
+const LockAttempts = 3
+
+// Lock Operations
+const (
+	LockOperationApply   = "apply"
+	LockOperationPlan    = "plan"
+	LockOperationStatus  = "status"
+	LockOperationStop    = "stop"
+	LockOperationCleanup = "cleanup"
+)
+
+// Lock describes the process holding the session lock. The lock is taken
+// while a session is planned, started, refreshed or stopped and while orphans
+// are cleaned up, so that two invocations can't act on the current session at
+// the same time.
+type Lock struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Operation string    `json:"operation"`
+	Created   time.Time `json:"created"`
+}
+
+// Stale reports whether the process that took the lock has gone away: it is
+// older than StaleLockAge, or was taken on this machine by a process that is
+// no longer running.
+func (l *Lock) Stale() bool {
+	if time.Since(l.Created) > StaleLockAge {
+		return true
+	}
+
+	host, err := os.Hostname()
+	if err != nil || l.Host != host || l.PID <= 0 {
+		return false
+	}
+
+	return !processRunning(l.PID)
+}
+
+func (c *Client) lockPath() string {
+	return fmt.Sprintf("%s/%s", c.Dir, SessionLock)
+}
+
+// Lock reads the session lock, returning nil if the session isn't locked.
+func (c *Client) Lock() (*Lock, error) {
+	return readLock(c.lockPath())
+}
+
+func readLock(path string) (*Lock, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var l Lock
+	if err := json.Unmarshal(bytes, &l); err != nil {
+		// The lock is still being written, or its holder died while writing
+		// it, so its age is all there is to go on.
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		l = Lock{Created: info.ModTime()}
+	}
+
+	return &l, nil
+}
+
+// same reports whether two locks were taken by the same call to lock.
+func (l *Lock) same(other *Lock) bool {
+	return l.PID == other.PID && l.Host == other.Host && l.Operation == other.Operation && l.Created.Equal(other.Created)
+}
+
+// removeLock removes the session lock only if it is still want. The lock is
+// first renamed aside, which only one process can do, and put back if it
+// turns out to have been replaced by another process in the meantime, so a
+// lock taken by someone else is never removed.
+func (c *Client) removeLock(want *Lock) error {
+	path := c.lockPath()
+	aside := fmt.Sprintf("%s.%d.%d", path, os.Getpid(), time.Now().UnixNano())
+
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	held, err := readLock(aside)
+	if err == nil && held != nil && held.same(want) {
+		return os.Remove(aside)
+	}
+
+	// Link fails rather than replacing a lock taken since it was renamed.
+	if err := os.Link(aside, path); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return os.Remove(aside)
+}
+
+// ForceUnlock removes the session lock whether or not its holder is still
+// running. It is for locks that are wrongly judged to be in use, such as one
+// left by a process that was killed on another machine.
+func (c *Client) ForceUnlock() error {
+	if err := os.Remove(c.lockPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// lock takes the session lock for an operation, replacing a stale lock, and
+// returns the function that releases it. ErrSessionBusy is returned if
+// another process holds the lock.
+//
+// The lock is a file in the client's directory, so it only guards against
+// invocations sharing that directory. A remote backend with locking keeps
+// Terraform runs from other machines apart.
+//
+// There is one lock for the directory rather than one per session name. The
+// directory holds a single current session and a single Terraform working
+// directory and state, which every command acts on whatever the session is
+// called, so two sessions with different names would still collide.
+func (c *Client) lock(operation string) (func(), error) {
+	path := c.lockPath()
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock is read back from the JSON so that it compares equal to what
+	// is read from the file when it is released.
+	bytes, err := json.Marshal(Lock{PID: os.Getpid(), Host: host, Operation: operation, Created: time.Now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	var own Lock
+	if err := json.Unmarshal(bytes, &own); err != nil {
+		return nil, err
+	}
+
+	var held *Lock
+	for attempt := 0; attempt < LockAttempts; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			if _, err := f.Write(bytes); err != nil {
+				f.Close()
+				os.Remove(path)
+				return nil, err
+			}
+
+			if err := f.Close(); err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+
+			return func() { c.removeLock(&own) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		held, err = c.Lock()
+		if err != nil {
+			return nil, err
+		}
+
+		// The lock was released between trying to take it and reading it.
+		if held == nil {
+			continue
+		}
+
+		if !held.Stale() {
+			return nil, &ErrSessionBusy{Lock: held, Path: path}
+		}
+
+		// Another process replacing the same stale lock may already have
+		// taken it, in which case the new lock is left alone and found on the
+		// next attempt.
+		if err := c.removeLock(held); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, &ErrSessionBusy{Lock: held, Path: path}
+}
@@ -0,0 +1,299 @@
+package parsec
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newLockTestClient(t *testing.T) (*Client, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClient(dir), func() { os.RemoveAll(dir) }
+}
+
+func writeLock(t *testing.T, c *Client, l Lock) {
+	t.Helper()
+
+	bytes, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(c.lockPath(), bytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitedPID is the id of a process that has finished.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("could not run a process: %v", err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestLockStale(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		lock Lock
+		want bool
+	}{
+		{name: "held by this process", lock: Lock{PID: os.Getpid(), Host: host, Created: now}, want: false},
+		{name: "too old", lock: Lock{PID: os.Getpid(), Host: host, Created: now.Add(-StaleLockAge - time.Minute)}, want: true},
+		{name: "another machine", lock: Lock{PID: 1, Host: host + "-other", Created: now}, want: false},
+		{name: "old from another machine", lock: Lock{PID: 1, Host: host + "-other", Created: now.Add(-StaleLockAge - time.Minute)}, want: true},
+		{name: "unknown holder", lock: Lock{Created: now}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lock.Stale(); got != tt.want {
+				t.Errorf("Stale() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("exited process", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("exited processes can't be told apart on Windows")
+		}
+
+		l := Lock{PID: exitedPID(t), Host: host, Created: now}
+		if !l.Stale() {
+			t.Error("Stale() = false for a process that has exited")
+		}
+	})
+}
+
+func TestLock(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	if held, err := c.Lock(); err != nil || held != nil {
+		t.Fatalf("Lock() = %v, %v before locking, want nothing", held, err)
+	}
+
+	unlock, err := c.lock(LockOperationStop)
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	held, err := c.Lock()
+	if err != nil || held == nil {
+		t.Fatalf("Lock() = %v, %v while locked", held, err)
+	}
+	if held.PID != os.Getpid() || held.Operation != LockOperationStop {
+		t.Errorf("Lock() = %+v, want this process stopping", held)
+	}
+
+	var busy *ErrSessionBusy
+	if _, err := c.lock(LockOperationApply); !errors.As(err, &busy) {
+		t.Fatalf("second lock() error = %v, want ErrSessionBusy", err)
+	}
+	if busy.Lock == nil || busy.Lock.Operation != LockOperationStop {
+		t.Errorf("ErrSessionBusy.Lock = %+v, want the stop lock", busy.Lock)
+	}
+
+	unlock()
+
+	if held, err := c.Lock(); err != nil || held != nil {
+		t.Fatalf("Lock() = %v, %v after unlocking, want nothing", held, err)
+	}
+}
+
+func TestLockReplacesStaleLocks(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	writeLock(t, c, Lock{PID: 1, Host: "elsewhere", Operation: LockOperationApply, Created: time.Now().Add(-StaleLockAge - time.Minute)})
+
+	unlock, err := c.lock(LockOperationStatus)
+	if err != nil {
+		t.Fatalf("lock() over a stale lock error = %v", err)
+	}
+	defer unlock()
+
+	if held, err := c.Lock(); err != nil || held == nil || held.PID != os.Getpid() {
+		t.Errorf("Lock() = %+v, %v, want this process", held, err)
+	}
+}
+
+func TestLockConcurrentTakeover(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	writeLock(t, c, Lock{PID: 1, Host: "elsewhere", Operation: LockOperationApply, Created: time.Now().Add(-StaleLockAge - time.Minute)})
+
+	const takeovers = 8
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		start   = make(chan struct{})
+		unlocks []func()
+	)
+
+	for i := 0; i < takeovers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			unlock, err := c.lock(LockOperationStop)
+
+			var busy *ErrSessionBusy
+			if err != nil && !errors.As(err, &busy) {
+				t.Errorf("lock() error = %v, want ErrSessionBusy", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				unlocks = append(unlocks, unlock)
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	if len(unlocks) != 1 {
+		t.Fatalf("%d of %d takeovers of a stale lock took it, want 1", len(unlocks), takeovers)
+	}
+
+	if held, err := c.Lock(); err != nil || held == nil || held.Operation != LockOperationStop {
+		t.Fatalf("Lock() = %+v, %v, want the winner's lock", held, err)
+	}
+
+	unlocks[0]()
+
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("%d file(s) left behind after unlocking, want none", len(files))
+	}
+}
+
+func TestUnlockLeavesOtherLocks(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	unlock, err := c.lock(LockOperationApply)
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	// Another process judged the lock stale and replaced it.
+	other := Lock{PID: 1, Host: "elsewhere", Operation: LockOperationStop, Created: time.Now().UTC()}
+	writeLock(t, c, other)
+
+	unlock()
+
+	held, err := c.Lock()
+	if err != nil || held == nil || !held.same(&other) {
+		t.Errorf("Lock() = %+v, %v after unlocking, want the other process's lock", held, err)
+	}
+}
+
+func TestRemoveLockReplaced(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	stale := Lock{PID: 1, Host: "elsewhere", Operation: LockOperationApply, Created: time.Now().Add(-StaleLockAge - time.Minute).UTC()}
+	fresh := Lock{PID: 2, Host: "elsewhere", Operation: LockOperationStop, Created: time.Now().UTC()}
+
+	// The stale lock was replaced after it was read.
+	writeLock(t, c, fresh)
+
+	if err := c.removeLock(&stale); err != nil {
+		t.Fatalf("removeLock() error = %v", err)
+	}
+
+	held, err := c.Lock()
+	if err != nil || held == nil || !held.same(&fresh) {
+		t.Fatalf("Lock() = %+v, %v, want the fresh lock to be left", held, err)
+	}
+
+	if err := c.removeLock(&fresh); err != nil {
+		t.Fatalf("removeLock() error = %v", err)
+	}
+	if held, err := c.Lock(); err != nil || held != nil {
+		t.Errorf("Lock() = %+v, %v after removing it, want nothing", held, err)
+	}
+}
+
+func TestLockUnreadable(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	// A lock that is still being written is judged by its age.
+	if err := ioutil.WriteFile(c.lockPath(), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var busy *ErrSessionBusy
+	if _, err := c.lock(LockOperationStop); !errors.As(err, &busy) {
+		t.Fatalf("lock() error = %v, want ErrSessionBusy", err)
+	}
+
+	old := time.Now().Add(-StaleLockAge - time.Minute)
+	if err := os.Chtimes(c.lockPath(), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := c.lock(LockOperationStop)
+	if err != nil {
+		t.Fatalf("lock() over an old unreadable lock error = %v", err)
+	}
+	unlock()
+}
+
+func TestForceUnlock(t *testing.T) {
+	c, cleanup := newLockTestClient(t)
+	defer cleanup()
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeLock(t, c, Lock{PID: os.Getpid(), Host: host, Operation: LockOperationApply, Created: time.Now()})
+
+	if err := c.ForceUnlock(); err != nil {
+		t.Fatalf("ForceUnlock() error = %v", err)
+	}
+	if err := c.ForceUnlock(); err != nil {
+		t.Errorf("ForceUnlock() without a lock error = %v", err)
+	}
+
+	unlock, err := c.lock(LockOperationApply)
+	if err != nil {
+		t.Fatalf("lock() after ForceUnlock() error = %v", err)
+	}
+	unlock()
+}
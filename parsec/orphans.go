@@ -0,0 +1,629 @@
+package parsec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Orphans are the resources created by parsec-ec2 for one user that were found
+// in a region. User is the user they are tagged with, which is empty for
+// resources created by older versions, and Owned reports whether that is the
+// user gc was run as. Only owned resources are ever cleaned up.
+type Orphans struct {
+	Region string
+	User   string
+	Owned  bool
+
+	SecurityGroups []*ec2.SecurityGroup
+	SpotRequests   []*ec2.SpotInstanceRequest
+	Instances      []*ec2.Instance
+	Volumes        []*ec2.Volume
+
+	// The network of a session started with --create-vpc.
+	Vpcs             []*ec2.Vpc
+	Subnets          []*ec2.Subnet
+	RouteTables      []*ec2.RouteTable
+	InternetGateways []*ec2.InternetGateway
+
+	// HourlyCost is what the running instances cost per hour at the current
+	// spot price, and MonthlyCost is what the volumes cost per month.
+	HourlyCost  float64
+	MonthlyCost float64
+}
+
+// Empty reports whether no resources were found.
+func (o Orphans) Empty() bool {
+	return len(o.SecurityGroups) == 0 && len(o.SpotRequests) == 0 && len(o.Instances) == 0 && len(o.Volumes) == 0 &&
+		len(o.Vpcs) == 0 && len(o.Subnets) == 0 && len(o.RouteTables) == 0 && len(o.InternetGateways) == 0
+}
+
+// regionOrphans collects the orphans found in a region by the user they
+// belong to.
+type regionOrphans struct {
+	region string
+	user   string
+	byUser map[string]*Orphans
+}
+
+func newRegionOrphans(region, user string) *regionOrphans {
+	return &regionOrphans{region: region, user: user, byUser: map[string]*Orphans{}}
+}
+
+func (r *regionOrphans) of(user string) *Orphans {
+	o, ok := r.byUser[user]
+	if !ok {
+		o = &Orphans{Region: r.region, User: user, Owned: len(user) > 0 && user == r.user}
+		r.byUser[user] = o
+	}
+	return o
+}
+
+// list returns the orphans found, with the caller's own first.
+func (r *regionOrphans) list() []Orphans {
+	var results []Orphans
+	for _, o := range r.byUser {
+		if !o.Empty() {
+			results = append(results, *o)
+		}
+	}
+
+	sortOrphans(results)
+	return results
+}
+
+func sortOrphans(results []Orphans) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Owned != results[j].Owned {
+			return results[i].Owned
+		}
+		if results[i].Region != results[j].Region {
+			return results[i].Region < results[j].Region
+		}
+		return results[i].User < results[j].User
+	})
+}
+
+// sessionResources identifies the resources belonging to the current session,
+// which must not be cleaned up.
+type sessionResources struct {
+	Session       string
+	Region        string
+	VpcID         string
+	SpotRequestID string
+	InstanceID    string
+}
+
+// FindOrphans scans every EC2 region concurrently for resources created by
+// parsec-ec2 that don't belong to the current session. The resources are
+// grouped by the user they are tagged with, so that user's own, which are
+// returned first, can be cleaned up without touching anyone else's in a
+// shared account. Regions that can't be scanned, for example because they
+// have not been enabled for the account, are reported in the returned
+// warnings rather than failing the whole scan.
+func (c *Client) FindOrphans(ctx context.Context, user string) ([]Orphans, []error, error) {
+	unlock, err := c.lock(LockOperationCleanup)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	current, errs, err := c.currentSessionResources(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []Orphans
+	)
+
+	for _, r := range Regions() {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+
+			found, err := c.findOrphans(ctx, region, user, current)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Skipped %s: %s", region, err))
+				return
+			}
+			results = append(results, found...)
+		}(r.ID())
+	}
+
+	wg.Wait()
+
+	sortOrphans(results)
+
+	return results, errs, nil
+}
+
+// currentSessionResources reads the current session, if there is one, so that
+// its resources are not mistaken for orphans. If its Terraform outputs can't
+// be read, its spot request and instance are only recognised by their session
+// tag, and a warning is returned.
+func (c *Client) currentSessionResources(ctx context.Context) (sessionResources, []error, error) {
+	p, err := c.Session(ctx)
+	if errors.Is(err, ErrNoSession) {
+		return sessionResources{}, nil, nil
+	}
+	if err != nil {
+		return sessionResources{}, nil, err
+	}
+
+	current := sessionResources{
+		Session: p.Session,
+		Region:  p.Region,
+		VpcID:   p.VpcID,
+	}
+
+	o, err := c.outputs(ctx, p)
+	if err != nil {
+		warning := fmt.Errorf("Could not read the Terraform outputs of the current session, so only its tagged resources are recognised as belonging to it: %s", err)
+		return current, []error{warning}, nil
+	}
+
+	current.SpotRequestID = o.SpotRequestID.Value
+	current.InstanceID = o.SpotInstanceID.Value
+
+	return current, nil, nil
+}
+
+// tagged reports whether a resource is tagged with the current session.
+func (s sessionResources) tagged(tags []*ec2.Tag) bool {
+	session := tagValue(tags, TagSession)
+	return len(session) > 0 && session == s.Session
+}
+
+func (c *Client) findOrphans(ctx context.Context, region, user string, current sessionResources) ([]Orphans, error) {
+	found := newRegionOrphans(region, user)
+
+	svc, err := c.newEc2Client(region)
+	if err != nil {
+		return nil, err
+	}
+
+	isCurrentRegion := region == current.Region
+	inCurrentVpc := func(vpcID string) bool {
+		return isCurrentRegion && len(vpcID) > 0 && vpcID == current.VpcID
+	}
+
+	// Security groups are found by the tags current versions put on them, and
+	// by the fixed name older versions gave them.
+	groupFilters := [][]*ec2.Filter{
+		{{Name: aws.String("tag-key"), Values: []*string{aws.String(TagSession)}}},
+		{{Name: aws.String("group-name"), Values: []*string{aws.String(LegacySecurityGroupName)}}},
+	}
+
+	seenGroups := map[string]bool{}
+	for _, filters := range groupFilters {
+		groups, err := svc.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{Filters: filters})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups.SecurityGroups {
+			id := aws.StringValue(group.GroupId)
+			if seenGroups[id] {
+				continue
+			}
+			seenGroups[id] = true
+
+			if current.tagged(group.Tags) {
+				continue
+			}
+			if len(tagValue(group.Tags, TagSession)) == 0 && inCurrentVpc(aws.StringValue(group.VpcId)) {
+				continue
+			}
+
+			o := found.of(tagValue(group.Tags, TagUser))
+			o.SecurityGroups = append(o.SecurityGroups, group)
+		}
+	}
+
+	requests, err := svc.DescribeSpotInstanceRequestsWithContext(ctx, &ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:Name"), Values: []*string{aws.String(InstanceName)}},
+			{Name: aws.String("state"), Values: aws.StringSlice([]string{"open", "active"})},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// requestOwners is who launched each instance, for instances the request's
+	// tags have not been propagated to yet.
+	requestOwners := map[string]string{}
+	for _, request := range requests.SpotInstanceRequests {
+		instanceID := aws.StringValue(request.InstanceId)
+		if current.tagged(request.Tags) {
+			continue
+		}
+		if isCurrentRegion && (aws.StringValue(request.SpotInstanceRequestId) == current.SpotRequestID ||
+			len(instanceID) > 0 && instanceID == current.InstanceID) {
+			continue
+		}
+
+		owner := tagValue(request.Tags, TagUser)
+		o := found.of(owner)
+		o.SpotRequests = append(o.SpotRequests, request)
+		if len(instanceID) > 0 {
+			requestOwners[instanceID] = owner
+		}
+	}
+
+	// Spot request tags are not copied to the instances they launch, and are
+	// only propagated once the request has been fulfilled, so the instances are
+	// found through the requests as well as by their own tags.
+	instanceFilters := [][]*ec2.Filter{
+		{{Name: aws.String("tag:Name"), Values: []*string{aws.String(InstanceName)}}},
+		{{Name: aws.String("tag-key"), Values: []*string{aws.String(TagSession)}}},
+	}
+	if len(requestOwners) > 0 {
+		var ids []string
+		for id := range requestOwners {
+			ids = append(ids, id)
+		}
+		instanceFilters = append(instanceFilters, []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice(ids)}})
+	}
+
+	// instanceOwners is who each orphaned instance belongs to, for the volumes
+	// attached to it.
+	instanceOwners := map[string]string{}
+	for _, filters := range instanceFilters {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("instance-state-name"),
+			Values: aws.StringSlice([]string{"pending", "running", "stopping", "stopped"}),
+		})
+
+		err := svc.DescribeInstancesPagesWithContext(ctx, &ec2.DescribeInstancesInput{Filters: filters}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, reservation := range page.Reservations {
+				for _, instance := range reservation.Instances {
+					id := aws.StringValue(instance.InstanceId)
+					if _, seen := instanceOwners[id]; seen || current.tagged(instance.Tags) || (isCurrentRegion && id == current.InstanceID) {
+						continue
+					}
+
+					owner := tagValue(instance.Tags, TagUser)
+					if len(owner) == 0 {
+						owner = requestOwners[id]
+					}
+					instanceOwners[id] = owner
+
+					o := found.of(owner)
+					o.Instances = append(o.Instances, instance)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, o := range found.byUser {
+		for _, instance := range o.Instances {
+			if aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+				continue
+			}
+
+			price, err := currentSpotPrice(ctx, svc, aws.StringValue(instance.InstanceType), aws.StringValue(instance.Placement.AvailabilityZone))
+			if err == nil {
+				o.HourlyCost += price
+			}
+		}
+	}
+
+	// Volumes that were attached to orphaned instances, or that were left
+	// behind detached after one was terminated.
+	volumeFilters := [][]*ec2.Filter{
+		{{Name: aws.String("tag-key"), Values: []*string{aws.String(TagSession)}}},
+	}
+	if len(instanceOwners) > 0 {
+		var ids []string
+		for id := range instanceOwners {
+			ids = append(ids, id)
+		}
+		volumeFilters = append(volumeFilters, []*ec2.Filter{{Name: aws.String("attachment.instance-id"), Values: aws.StringSlice(ids)}})
+	}
+
+	seenVolumes := map[string]bool{}
+	for _, filters := range volumeFilters {
+		volumes, err := svc.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{Filters: filters})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, volume := range volumes.Volumes {
+			id := aws.StringValue(volume.VolumeId)
+			if seenVolumes[id] || attachedTo(volume, current.InstanceID) {
+				continue
+			}
+			if current.tagged(volume.Tags) {
+				continue
+			}
+			seenVolumes[id] = true
+
+			owner := tagValue(volume.Tags, TagUser)
+			for _, attachment := range volume.Attachments {
+				if len(owner) == 0 {
+					owner = instanceOwners[aws.StringValue(attachment.InstanceId)]
+				}
+			}
+
+			o := found.of(owner)
+			o.Volumes = append(o.Volumes, volume)
+			o.MonthlyCost += ebsMonthlyCost(region, aws.StringValue(volume.VolumeType), int(aws.Int64Value(volume.Size)), int(aws.Int64Value(volume.Iops)), int(aws.Int64Value(volume.Throughput)))
+		}
+	}
+
+	if err := findOrphanedNetworks(ctx, svc, found, current, inCurrentVpc); err != nil {
+		return nil, err
+	}
+
+	return found.list(), nil
+}
+
+// findOrphanedNetworks finds the VPCs, subnets, route tables and internet
+// gateways of sessions started with --create-vpc, which are all tagged with
+// their session.
+func findOrphanedNetworks(ctx context.Context, svc *ec2.EC2, found *regionOrphans, current sessionResources, inCurrentVpc func(string) bool) error {
+	filters := []*ec2.Filter{{Name: aws.String("tag-key"), Values: []*string{aws.String(TagSession)}}}
+
+	vpcs, err := svc.DescribeVpcsWithContext(ctx, &ec2.DescribeVpcsInput{Filters: filters})
+	if err != nil {
+		return err
+	}
+	for _, vpc := range vpcs.Vpcs {
+		if current.tagged(vpc.Tags) || inCurrentVpc(aws.StringValue(vpc.VpcId)) {
+			continue
+		}
+		o := found.of(tagValue(vpc.Tags, TagUser))
+		o.Vpcs = append(o.Vpcs, vpc)
+	}
+
+	subnets, err := svc.DescribeSubnetsWithContext(ctx, &ec2.DescribeSubnetsInput{Filters: filters})
+	if err != nil {
+		return err
+	}
+	for _, subnet := range subnets.Subnets {
+		if current.tagged(subnet.Tags) || inCurrentVpc(aws.StringValue(subnet.VpcId)) {
+			continue
+		}
+		o := found.of(tagValue(subnet.Tags, TagUser))
+		o.Subnets = append(o.Subnets, subnet)
+	}
+
+	routeTables, err := svc.DescribeRouteTablesWithContext(ctx, &ec2.DescribeRouteTablesInput{Filters: filters})
+	if err != nil {
+		return err
+	}
+	for _, table := range routeTables.RouteTables {
+		if current.tagged(table.Tags) || inCurrentVpc(aws.StringValue(table.VpcId)) {
+			continue
+		}
+		o := found.of(tagValue(table.Tags, TagUser))
+		o.RouteTables = append(o.RouteTables, table)
+	}
+
+	gateways, err := svc.DescribeInternetGatewaysWithContext(ctx, &ec2.DescribeInternetGatewaysInput{Filters: filters})
+	if err != nil {
+		return err
+	}
+	for _, gateway := range gateways.InternetGateways {
+		if current.tagged(gateway.Tags) {
+			continue
+		}
+
+		attachedToCurrent := false
+		for _, attachment := range gateway.Attachments {
+			attachedToCurrent = attachedToCurrent || inCurrentVpc(aws.StringValue(attachment.VpcId))
+		}
+		if attachedToCurrent {
+			continue
+		}
+
+		o := found.of(tagValue(gateway.Tags, TagUser))
+		o.InternetGateways = append(o.InternetGateways, gateway)
+	}
+
+	return nil
+}
+
+func tagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+func attachedTo(volume *ec2.Volume, instanceID string) bool {
+	if len(instanceID) == 0 {
+		return false
+	}
+
+	for _, attachment := range volume.Attachments {
+		if aws.StringValue(attachment.InstanceId) == instanceID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// currentSpotPrice is the latest Windows spot price for an instance type in an
+// availability zone.
+func currentSpotPrice(ctx context.Context, svc *ec2.EC2, instanceType, availabilityZone string) (float64, error) {
+	now := time.Now()
+
+	result, err := svc.DescribeSpotPriceHistoryWithContext(ctx, &ec2.DescribeSpotPriceHistoryInput{
+		AvailabilityZone:    aws.String(availabilityZone),
+		InstanceTypes:       []*string{aws.String(instanceType)},
+		ProductDescriptions: []*string{aws.String(Windows)},
+		StartTime:           aws.Time(now),
+		EndTime:             aws.Time(now),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(result.SpotPriceHistory) == 0 {
+		return 0, fmt.Errorf("No spot price found for %s in %s.", instanceType, availabilityZone)
+	}
+
+	return strconv.ParseFloat(aws.StringValue(result.SpotPriceHistory[0].SpotPrice), 64)
+}
+
+// CleanupOrphans removes orphans in the order their dependencies allow:
+// requests are cancelled so they don't launch replacements, instances are
+// terminated and waited on, and only then can their volumes and security
+// groups be deleted, followed by the subnets, route tables, internet gateways
+// and VPCs of their network. Orphans belonging to another user, or to no
+// user, are refused with ErrNotOwner.
+func (c *Client) CleanupOrphans(ctx context.Context, o Orphans) error {
+	if !o.Owned {
+		return &ErrNotOwner{Region: o.Region, User: o.User}
+	}
+
+	unlock, err := c.lock(LockOperationCleanup)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	svc, err := c.newEc2Client(o.Region)
+	if err != nil {
+		return err
+	}
+
+	if len(o.SpotRequests) > 0 {
+		var ids []*string
+		for _, request := range o.SpotRequests {
+			ids = append(ids, request.SpotInstanceRequestId)
+		}
+
+		if _, err := svc.CancelSpotInstanceRequestsWithContext(ctx, &ec2.CancelSpotInstanceRequestsInput{SpotInstanceRequestIds: ids}); err != nil {
+			return err
+		}
+	}
+
+	if len(o.Instances) > 0 {
+		var ids []*string
+		for _, instance := range o.Instances {
+			ids = append(ids, instance.InstanceId)
+		}
+
+		if _, err := svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{InstanceIds: ids}); err != nil {
+			return err
+		}
+
+		if err := svc.WaitUntilInstanceTerminatedWithContext(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}); err != nil {
+			return err
+		}
+	}
+
+	for _, volume := range o.Volumes {
+		// Volumes that were deleted along with their instance are already gone.
+		_, err := svc.DeleteVolumeWithContext(ctx, &ec2.DeleteVolumeInput{VolumeId: volume.VolumeId})
+		if err != nil && !isAWSErrorCode(err, "InvalidVolume.NotFound") {
+			return err
+		}
+	}
+
+	for _, group := range o.SecurityGroups {
+		err := deleteWithRetry(ctx, "InvalidGroup.NotFound", func() error {
+			_, err := svc.DeleteSecurityGroupWithContext(ctx, &ec2.DeleteSecurityGroupInput{GroupId: group.GroupId})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Deleting a subnet removes its route table association.
+	for _, subnet := range o.Subnets {
+		err := deleteWithRetry(ctx, "InvalidSubnetID.NotFound", func() error {
+			_, err := svc.DeleteSubnetWithContext(ctx, &ec2.DeleteSubnetInput{SubnetId: subnet.SubnetId})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, table := range o.RouteTables {
+		_, err := svc.DeleteRouteTableWithContext(ctx, &ec2.DeleteRouteTableInput{RouteTableId: table.RouteTableId})
+		if err != nil && !isAWSErrorCode(err, "InvalidRouteTableID.NotFound") {
+			return err
+		}
+	}
+
+	for _, gateway := range o.InternetGateways {
+		for _, attachment := range gateway.Attachments {
+			_, err := svc.DetachInternetGatewayWithContext(ctx, &ec2.DetachInternetGatewayInput{
+				InternetGatewayId: gateway.InternetGatewayId,
+				VpcId:             attachment.VpcId,
+			})
+			if err != nil && !isAWSErrorCode(err, "Gateway.NotAttached") && !isAWSErrorCode(err, "InvalidInternetGatewayID.NotFound") {
+				return err
+			}
+		}
+
+		_, err := svc.DeleteInternetGatewayWithContext(ctx, &ec2.DeleteInternetGatewayInput{InternetGatewayId: gateway.InternetGatewayId})
+		if err != nil && !isAWSErrorCode(err, "InvalidInternetGatewayID.NotFound") {
+			return err
+		}
+	}
+
+	for _, vpc := range o.Vpcs {
+		err := deleteWithRetry(ctx, "InvalidVpcID.NotFound", func() error {
+			_, err := svc.DeleteVpcWithContext(ctx, &ec2.DeleteVpcInput{VpcId: vpc.VpcId})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteWithRetry calls del, retrying with backoff while the resource is
+// still in use by the network interfaces of terminated instances. A resource
+// that is already gone, reported with the notFound error code, is not an
+// error.
+func deleteWithRetry(ctx context.Context, notFound string, del func() error) error {
+	delay := DeleteRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := del()
+		if err == nil || isAWSErrorCode(err, notFound) {
+			return nil
+		}
+
+		if !isAWSErrorCode(err, "DependencyViolation") || attempt == DeleteAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,87 @@
+package parsec
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRegionOrphans(t *testing.T) {
+	found := newRegionOrphans("eu-west-1", "jade")
+
+	found.of("sam").Instances = append(found.of("sam").Instances, &ec2.Instance{InstanceId: aws.String("i-sam")})
+	found.of("").SecurityGroups = append(found.of("").SecurityGroups, &ec2.SecurityGroup{GroupId: aws.String("sg-legacy")})
+	found.of("jade").Vpcs = append(found.of("jade").Vpcs, &ec2.Vpc{VpcId: aws.String("vpc-jade")})
+	found.of("alex")
+
+	results := found.list()
+
+	if len(results) != 3 {
+		t.Fatalf("list() = %+v, want the three users with resources", results)
+	}
+
+	// The caller's own come first, and are the only ones owned.
+	want := []struct {
+		user  string
+		owned bool
+	}{{"jade", true}, {"", false}, {"sam", false}}
+	for i, w := range want {
+		if results[i].User != w.user || results[i].Owned != w.owned || results[i].Region != "eu-west-1" {
+			t.Errorf("list()[%d] = %s owned %t in %s, want %s owned %t", i, results[i].User, results[i].Owned, results[i].Region, w.user, w.owned)
+		}
+	}
+}
+
+func TestRegionOrphansUntaggedCaller(t *testing.T) {
+	// Resources without a user tag are never owned, even by a caller whose
+	// user is empty.
+	found := newRegionOrphans("eu-west-1", "")
+	found.of("").Instances = append(found.of("").Instances, &ec2.Instance{InstanceId: aws.String("i-legacy")})
+
+	if results := found.list(); len(results) != 1 || results[0].Owned {
+		t.Errorf("list() = %+v, want one orphan that isn't owned", results)
+	}
+}
+
+func TestOrphansEmpty(t *testing.T) {
+	if !(Orphans{}).Empty() {
+		t.Error("Empty() of no resources = false")
+	}
+
+	for name, o := range map[string]Orphans{
+		"vpc":              {Vpcs: []*ec2.Vpc{{}}},
+		"subnet":           {Subnets: []*ec2.Subnet{{}}},
+		"route table":      {RouteTables: []*ec2.RouteTable{{}}},
+		"internet gateway": {InternetGateways: []*ec2.InternetGateway{{}}},
+		"volume":           {Volumes: []*ec2.Volume{{}}},
+	} {
+		if o.Empty() {
+			t.Errorf("Empty() with a %s = true", name)
+		}
+	}
+}
+
+func TestCleanupOrphansNotOwned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewClient(dir)
+
+	for _, o := range []Orphans{
+		{Region: "eu-west-1", User: "sam", Instances: []*ec2.Instance{{InstanceId: aws.String("i-sam")}}},
+		{Region: "eu-west-1", Instances: []*ec2.Instance{{InstanceId: aws.String("i-legacy")}}},
+	} {
+		var notOwner *ErrNotOwner
+		if err := c.CleanupOrphans(context.Background(), o); !errors.As(err, &notOwner) {
+			t.Errorf("CleanupOrphans() of %q's resources error = %v, want ErrNotOwner", o.User, err)
+		}
+	}
+}
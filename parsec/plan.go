@@ -0,0 +1,271 @@
+package parsec
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// PlanMaxAge is how long a saved plan can be applied for. Spot prices move,
+// so a plan older than this is likely to bid the wrong amount.
+const PlanMaxAge = 30 * time.Minute
+
+// planIDPattern matches the ids made by newPlanID, which are used as the names
+// of the plan directories.
+var planIDPattern = regexp.MustCompile(`^[0-9a-f]{8}$`)
+
+// Plan is a reviewed 'terraform plan' for a session, saved so that exactly
+// what was reviewed is applied.
+type Plan struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Session *Session  `json:"session"`
+}
+
+// Plan runs 'terraform plan' for a prepared session, writing the resources
+// that would be created to c.Stdout, and saves the plan so that it can be
+// applied with ApplyPlan.
+//
+// Terraform plan files hold the values of every variable, so the plan is
+// readable only by the user, and is removed once it has been applied or has
+// expired. It returns ErrSessionExists if a session is already running.
+func (c *Client) Plan(ctx context.Context, p *Session) (*Plan, error) {
+	unlock, err := c.lock(LockOperationPlan)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := c.Session(ctx); !errors.Is(err, ErrNoSession) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionExists
+	}
+
+	if err := c.removeExpiredPlans(); err != nil {
+		return nil, err
+	}
+
+	id, err := newPlanID()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := Plan{ID: id, Created: time.Now().UTC(), Session: p}
+
+	if err := os.MkdirAll(c.planDir(id), 0700); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.terraform(ctx, p, TfCmdPlan, fmt.Sprintf("%s=%s", TfFlagOut, c.planFile(id))); err != nil {
+		os.RemoveAll(c.planDir(id))
+		return nil, err
+	}
+
+	bytes, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", c.planDir(id), PlanSession), bytes, 0600); err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// LoadPlan reads a saved plan, returning ErrNoPlan if there isn't one with
+// the given id.
+func (c *Client) LoadPlan(id string) (*Plan, error) {
+	if err := validatePlanID(id); err != nil {
+		return nil, err
+	}
+
+	bytes, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", c.planDir(id), PlanSession))
+	if os.IsNotExist(err) {
+		return nil, &ErrNoPlan{ID: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(bytes, &plan); err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// ApplyPlan makes the spot request exactly as it was saved by Plan. It
+// refuses with ErrStalePlan if the plan can no longer be applied as reviewed:
+// it has expired, a session is already running, the spot price has risen
+// above the bid, or the address allowed through the security group has
+// changed. A refused plan is removed, since it holds the server key and can
+// never be applied. resolver looks up the current address, and the defaults
+// are used if it is nil.
+func (c *Client) ApplyPlan(ctx context.Context, id string, resolver IPResolver) (*ApplyResult, error) {
+	if err := validatePlanID(id); err != nil {
+		return nil, err
+	}
+
+	unlock, err := c.lock(LockOperationApply)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	plan, err := c.LoadPlan(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkPlan(ctx, plan, resolver); err != nil {
+		var stale *ErrStalePlan
+		if errors.As(err, &stale) {
+			if err := os.RemoveAll(c.planDir(id)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := c.removeExpiredPlans(); err != nil {
+		return nil, err
+	}
+
+	p := plan.Session
+
+	r, err := c.apply(ctx, p, func() error {
+		if err := c.writeTfVars(p, c.TfVarsPath(p)); err != nil {
+			return err
+		}
+
+		command, err := c.tfCmd(ctx, []string{TfCmdApply, TfFlagNoColor, c.planFile(id)})
+		if err != nil {
+			return err
+		}
+
+		_, err = c.runSession(ctx, p, command)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, os.RemoveAll(c.planDir(id))
+}
+
+// RemovePlan discards a saved plan.
+func (c *Client) RemovePlan(id string) error {
+	if err := validatePlanID(id); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(c.planDir(id))
+}
+
+func (c *Client) checkPlan(ctx context.Context, plan *Plan, resolver IPResolver) error {
+	p := plan.Session
+
+	if age := time.Since(plan.Created); age > PlanMaxAge {
+		return &ErrStalePlan{ID: plan.ID, Reason: fmt.Sprintf("it was made %s ago, and plans can only be applied for %s", age.Round(time.Minute), PlanMaxAge)}
+	}
+
+	if _, err := c.Session(ctx); !errors.Is(err, ErrNoSession) {
+		if err != nil {
+			return err
+		}
+		return &ErrStalePlan{ID: plan.ID, Reason: "a session is already running"}
+	}
+
+	svc, err := c.newEc2Client(p.Region)
+	if err != nil {
+		return err
+	}
+
+	price, err := currentSpotPrice(ctx, svc, p.InstanceType, p.AvailabilityZone)
+	if err != nil {
+		return err
+	}
+
+	bid, err := strconv.ParseFloat(p.SpotPrice, 64)
+	if err != nil {
+		return err
+	}
+
+	if price > bid {
+		return &ErrStalePlan{ID: plan.ID, Reason: fmt.Sprintf("the spot price in %s has risen to $%.4f, above the bid of $%s", p.AvailabilityZone, price, p.SpotPrice)}
+	}
+
+	if resolver == nil {
+		resolver = NewIPResolver("", DefaultIPProviders(), DefaultSTUNServers(), DefaultIPTimeout)
+	}
+
+	ip, err := resolver.ResolveIP(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current := fmt.Sprintf("%s/%s", ip, "32"); current != p.IP {
+		return &ErrStalePlan{ID: plan.ID, Reason: fmt.Sprintf("your address has changed from %s to %s", p.IP, current)}
+	}
+
+	return nil
+}
+
+// removeExpiredPlans removes the plans that can no longer be applied, so that
+// plans which are never applied don't leave the server key on disk. It is run
+// whenever a plan is made or applied and whenever the status is checked.
+func (c *Client) removeExpiredPlans() error {
+	dirs, err := ioutil.ReadDir(fmt.Sprintf("%s/%s", c.Dir, Plans))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if time.Since(dir.ModTime()) > PlanMaxAge {
+			if err := os.RemoveAll(c.planDir(dir.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) planDir(id string) string {
+	return fmt.Sprintf("%s/%s/%s", c.Dir, Plans, id)
+}
+
+func (c *Client) planFile(id string) string {
+	return fmt.Sprintf("%s/%s", c.planDir(id), PlanFile)
+}
+
+func validatePlanID(id string) error {
+	if !planIDPattern.MatchString(id) {
+		return &ErrInvalidPlanID{ID: id}
+	}
+
+	return nil
+}
+
+func newPlanID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,190 @@
+package parsec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestValidatePlanID(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		id, err := newPlanID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validatePlanID(id); err != nil {
+			t.Errorf("validatePlanID(%q) error = %v", id, err)
+		}
+	}
+
+	for _, id := range []string{"", "3f9a1c2", "3f9a1c2e0", "3F9A1C2E", "3f9a1c2g", "../../x", "3f9a/c2e", "."} {
+		var invalid *ErrInvalidPlanID
+		if err := validatePlanID(id); !errors.As(err, &invalid) {
+			t.Errorf("validatePlanID(%q) error = %v, want ErrInvalidPlanID", id, err)
+		}
+	}
+}
+
+func TestPlanIDsAreValidatedBeforeUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A file outside the plans directory that a crafted id could reach.
+	outside := fmt.Sprintf("%s/%s", dir, PlanSession)
+	if err := ioutil.WriteFile(outside, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(dir)
+	id := fmt.Sprintf("../%s", Plans)
+
+	var invalid *ErrInvalidPlanID
+	if _, err := c.LoadPlan(".."); !errors.As(err, &invalid) {
+		t.Errorf("LoadPlan() error = %v, want ErrInvalidPlanID", err)
+	}
+	if _, err := c.ApplyPlan(context.Background(), "..", nil); !errors.As(err, &invalid) {
+		t.Errorf("ApplyPlan() error = %v, want ErrInvalidPlanID", err)
+	}
+	if err := c.RemovePlan(id); !errors.As(err, &invalid) {
+		t.Errorf("RemovePlan() error = %v, want ErrInvalidPlanID", err)
+	}
+	if err := c.RemovePlan(".."); !errors.As(err, &invalid) {
+		t.Errorf("RemovePlan() error = %v, want ErrInvalidPlanID", err)
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("a file outside the plans directory was touched: %v", err)
+	}
+
+	var noPlan *ErrNoPlan
+	if _, err := c.LoadPlan("3f9a1c2e"); !errors.As(err, &noPlan) {
+		t.Errorf("LoadPlan() of a missing plan error = %v, want ErrNoPlan", err)
+	}
+}
+
+func TestPlanRefusesWhileASessionIsRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	c := NewClient(dir)
+
+	if err := c.writeSession(ctx, &Session{Session: "weeknight"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Plan(ctx, &Session{Session: "another"}); !errors.Is(err, ErrSessionExists) {
+		t.Errorf("Plan() error = %v, want ErrSessionExists", err)
+	}
+
+	if lock, err := c.Lock(); err != nil || lock != nil {
+		t.Errorf("Lock() = %v, %v after Plan(), want the lock released", lock, err)
+	}
+}
+
+// writePlan saves a plan made at created as Plan would, without running
+// Terraform.
+func writePlan(t *testing.T, c *Client, id string, created time.Time) {
+	t.Helper()
+
+	if err := os.MkdirAll(c.planDir(id), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.planFile(id), []byte("server_key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bytes, err := json.Marshal(Plan{ID: id, Created: created, Session: &Session{Session: "weeknight"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", c.planDir(id), PlanSession), bytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(c.planDir(id), created, created); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyPlanRemovesRefusedPlans(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		created time.Time
+		session bool
+	}{
+		{name: "expired", created: time.Now().Add(-PlanMaxAge - time.Minute)},
+		{name: "session running", created: time.Now(), session: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "parsec-ec2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			c := NewClient(dir)
+			id := "3f9a1c2e"
+			writePlan(t, c, id, tt.created)
+
+			if tt.session {
+				if err := c.writeSession(ctx, &Session{Session: "weeknight"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var stale *ErrStalePlan
+			if _, err := c.ApplyPlan(ctx, id, nil); !errors.As(err, &stale) {
+				t.Fatalf("ApplyPlan() error = %v, want ErrStalePlan", err)
+			}
+
+			if _, err := os.Stat(c.planDir(id)); !os.IsNotExist(err) {
+				t.Errorf("the refused plan's directory is still there: %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoveExpiredPlans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewClient(dir)
+
+	if err := c.removeExpiredPlans(); err != nil {
+		t.Errorf("removeExpiredPlans() without a plans directory error = %v", err)
+	}
+
+	writePlan(t, c, "3f9a1c2e", time.Now().Add(-PlanMaxAge-time.Minute))
+	writePlan(t, c, "0badcafe", time.Now())
+
+	// Status removes expired plans even when there is no session.
+	if _, err := c.Status(context.Background()); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("Status() error = %v, want ErrNoSession", err)
+	}
+
+	if _, err := os.Stat(c.planDir("3f9a1c2e")); !os.IsNotExist(err) {
+		t.Errorf("the expired plan is still there: %v", err)
+	}
+	if _, err := c.LoadPlan("0badcafe"); err != nil {
+		t.Errorf("LoadPlan() of the current plan error = %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package parsec
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts Terraform in its own process group, so that a Ctrl-C
+// in the terminal only reaches parsec-ec2. Terraform exits immediately without
+// saving its state on a second interrupt, so it must only get the one sent
+// when the context is cancelled.
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func interrupt(process *os.Process) error {
+	return process.Signal(os.Interrupt)
+}
+
+// processRunning reports whether a process exists. EPERM means it exists but
+// belongs to another user.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
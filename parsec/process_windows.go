@@ -0,0 +1,30 @@
+//go:build windows
+
+package parsec
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup leaves Terraform in the console's process group on Windows,
+// where it receives a Ctrl-C in the console directly. Interrupts can't be
+// sent to another process, so a cancelled context only kills Terraform once
+// the grace period has passed.
+func setProcessGroup(command *exec.Cmd) {}
+
+func interrupt(process *os.Process) error {
+	return nil
+}
+
+// processRunning reports whether a process exists. FindProcess opens a handle
+// to the process on Windows, which fails once it has exited.
+func processRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	process.Release()
+	return true
+}
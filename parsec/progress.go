@@ -0,0 +1,153 @@
+package parsec
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ProvisionMarker starts the lines user_data.tmpl writes to the instance's
+// console as provisioning progresses:
+//
+//	PARSEC-EC2 <time> <state> <step>: <message>
+const ProvisionMarker = "PARSEC-EC2"
+
+// ProvisionedDir is where the instance records the provisioning snippets that
+// have succeeded, so that they aren't run again when it reboots.
+const ProvisionedDir = `C:\ProgramData\parsec-ec2\provisioned`
+
+// Provisioning Step States
+const (
+	ProvisionStarted = "started"
+	ProvisionDone    = "done"
+	ProvisionFailed  = "failed"
+)
+
+// Provisioning Steps, other than the provisioning snippets, which are named
+// after their files. ProvisionStepAll is started when the user data starts
+// running, on every boot, and done when it has finished.
+const (
+	ProvisionStepAll          = "provisioning"
+	ProvisionStepParsecConfig = "parsec-config"
+	ProvisionStepWatchdog     = "watchdog"
+)
+
+// ProvisionStep is the last state the instance reported for a step.
+type ProvisionStep struct {
+	Name    string
+	State   string
+	Time    time.Time
+	Message string
+}
+
+// ProvisionProgress is how far the user data got on its last run, as read
+// from the instance's console output.
+type ProvisionProgress struct {
+	Started time.Time
+
+	// Steps are in the order they were first reported, without
+	// ProvisionStepAll.
+	Steps []ProvisionStep
+
+	// Finished is set once the user data has run to the end.
+	Finished bool
+}
+
+// Failed are the steps that failed.
+func (p *ProvisionProgress) Failed() []ProvisionStep {
+	var failed []ProvisionStep
+	for _, step := range p.Steps {
+		if step.State == ProvisionFailed {
+			failed = append(failed, step)
+		}
+	}
+
+	return failed
+}
+
+// parseProvisionProgress reads the progress markers in console output,
+// returning nil if there are none. Only the last run of the user data is
+// kept.
+func parseProvisionProgress(output string) *ProvisionProgress {
+	var progress *ProvisionProgress
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, ProvisionMarker+" "); i >= 0 {
+			line = line[i+len(ProvisionMarker)+1:]
+		} else {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+
+		t, _ := time.Parse(time.RFC3339, fields[0])
+		state := fields[1]
+		name, message := fields[2], ""
+		if i := strings.Index(name, ":"); i >= 0 {
+			name, message = name[:i], strings.TrimSpace(name[i+1:])
+		}
+
+		if name == ProvisionStepAll {
+			if state == ProvisionStarted || progress == nil {
+				progress = &ProvisionProgress{Started: t}
+			}
+			progress.Finished = state == ProvisionDone
+			continue
+		}
+
+		// Markers from before the first run was reported are ignored.
+		if progress == nil {
+			continue
+		}
+
+		step := ProvisionStep{Name: name, State: state, Time: t, Message: message}
+		replaced := false
+		for i := range progress.Steps {
+			if progress.Steps[i].Name == name {
+				progress.Steps[i] = step
+				replaced = true
+			}
+		}
+		if !replaced {
+			progress.Steps = append(progress.Steps, step)
+		}
+	}
+
+	return progress
+}
+
+// provisionProgress reads the progress markers from an instance's console
+// output. The latest output is asked for, which only Nitro instances can
+// give, and otherwise EC2 only updates the output a few times while the
+// instance boots, so progress can lag behind the instance.
+func provisionProgress(ctx context.Context, svc *ec2.EC2, instanceID string) (*ProvisionProgress, error) {
+	result, err := svc.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{
+		InstanceId: aws.String(instanceID),
+		Latest:     aws.Bool(true),
+	})
+	if isAWSErrorCode(err, "UnsupportedOperation") {
+		result, err = svc.GetConsoleOutputWithContext(ctx, &ec2.GetConsoleOutputInput{
+			InstanceId: aws.String(instanceID),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := base64.StdEncoding.DecodeString(aws.StringValue(result.Output))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProvisionProgress(string(output)), nil
+}
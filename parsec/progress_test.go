@@ -0,0 +1,115 @@
+package parsec
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseProvisionProgress(t *testing.T) {
+	at := func(minute int) time.Time { return time.Date(2020, 1, 6, 19, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		output string
+		want   *ProvisionProgress
+	}{
+		{name: "no output", output: "", want: nil},
+		{name: "no markers", output: "Windows is starting\r\nEC2Launch: done\r\n", want: nil},
+		{
+			name: "running",
+			output: "2020/01/06 19:02:00Z: EC2Launch running user data\r\n" +
+				"PARSEC-EC2 2020-01-06T19:02:00Z started provisioning: \r\n" +
+				"PARSEC-EC2 2020-01-06T19:03:00Z done parsec-config: \r\n" +
+				"PARSEC-EC2 2020-01-06T19:04:00Z started 10-steam.ps1: \r\n",
+			want: &ProvisionProgress{
+				Started: at(2),
+				Steps: []ProvisionStep{
+					{Name: ProvisionStepParsecConfig, State: ProvisionDone, Time: at(3)},
+					{Name: "10-steam.ps1", State: ProvisionStarted, Time: at(4)},
+				},
+			},
+		},
+		{
+			name: "finished with a failure",
+			output: "PARSEC-EC2 2020-01-06T19:02:00Z started provisioning: \n" +
+				"PARSEC-EC2 2020-01-06T19:03:00Z started 10-steam.ps1: \n" +
+				"PARSEC-EC2 2020-01-06T19:05:00Z failed 10-steam.ps1: The remote name could not be resolved:   'steampowered.com'\n" +
+				"PARSEC-EC2 2020-01-06T19:06:00Z done provisioning: \n",
+			want: &ProvisionProgress{
+				Started: at(2),
+				Steps: []ProvisionStep{
+					{Name: "10-steam.ps1", State: ProvisionFailed, Time: at(5), Message: "The remote name could not be resolved:   'steampowered.com'"},
+				},
+				Finished: true,
+			},
+		},
+		{
+			name: "prefixed by the console",
+			output: "[   12.345] PARSEC-EC2 2020-01-06T19:02:00Z started provisioning: \n" +
+				"[   13.000] PARSEC-EC2 2020-01-06T19:03:00Z done watchdog: \n",
+			want: &ProvisionProgress{
+				Started: at(2),
+				Steps:   []ProvisionStep{{Name: ProvisionStepWatchdog, State: ProvisionDone, Time: at(3)}},
+			},
+		},
+		{
+			name: "only the last boot is kept",
+			output: "PARSEC-EC2 2020-01-06T19:02:00Z started provisioning: \n" +
+				"PARSEC-EC2 2020-01-06T19:03:00Z failed 10-steam.ps1: timed out\n" +
+				"PARSEC-EC2 2020-01-06T19:04:00Z done provisioning: \n" +
+				"PARSEC-EC2 2020-01-06T19:10:00Z started provisioning: \n" +
+				"PARSEC-EC2 2020-01-06T19:11:00Z done 20-tailscale.ps1: already run on an earlier boot\n",
+			want: &ProvisionProgress{
+				Started: at(10),
+				Steps:   []ProvisionStep{{Name: "20-tailscale.ps1", State: ProvisionDone, Time: at(11), Message: "already run on an earlier boot"}},
+			},
+		},
+		{
+			name: "markers before the run started",
+			output: "PARSEC-EC2 2020-01-06T19:01:00Z done parsec-config: \n" +
+				"PARSEC-EC2 2020-01-06T19:02:00Z started provisioning: \n",
+			want: &ProvisionProgress{Started: at(2)},
+		},
+		{
+			name:   "run started before the output begins",
+			output: "PARSEC-EC2 2020-01-06T19:03:00Z done provisioning: \n",
+			want:   &ProvisionProgress{Started: at(3), Finished: true},
+		},
+		{
+			name: "malformed markers",
+			output: "PARSEC-EC2\n" +
+				"PARSEC-EC2 2020-01-06T19:02:00Z\n" +
+				"PARSEC-EC2-OTHER 2020-01-06T19:02:00Z started provisioning: \n" +
+				"PARSEC-EC2 2020-01-06T19:02:00Z started provisioning: \n" +
+				"PARSEC-EC2 not-a-time done watchdog: \n",
+			want: &ProvisionProgress{
+				Started: at(2),
+				Steps:   []ProvisionStep{{Name: ProvisionStepWatchdog, State: ProvisionDone}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseProvisionProgress(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProvisionProgress() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvisionProgressFailed(t *testing.T) {
+	p := &ProvisionProgress{Steps: []ProvisionStep{
+		{Name: ProvisionStepParsecConfig, State: ProvisionDone},
+		{Name: "10-steam.ps1", State: ProvisionFailed, Message: "timed out"},
+		{Name: "20-tailscale.ps1", State: ProvisionStarted},
+		{Name: "30-drivers.ps1", State: ProvisionFailed},
+	}}
+
+	failed := p.Failed()
+	if len(failed) != 2 || failed[0].Name != "10-steam.ps1" || failed[1].Name != "30-drivers.ps1" {
+		t.Errorf("Failed() = %+v, want the two failed snippets", failed)
+	}
+}
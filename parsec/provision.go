@@ -0,0 +1,215 @@
+package parsec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// MaxUserDataSize is the most user data EC2 accepts, before it is base64
+// encoded.
+const MaxUserDataSize = 16 * 1024
+
+// ProvisionVars are the session variables provisioning snippets are rendered
+// with, such as {{ .Region }} or {{ if .Volumes.HasDataVolume }}.
+type ProvisionVars struct {
+	Session            string
+	User               string
+	Region             string
+	AvailabilityZone   string
+	InstanceType       string
+	Tags               map[string]string
+	Volumes            Volumes
+	Host               HostSettings
+	MaxSessionMinutes  int
+	IdleTimeoutMinutes int
+}
+
+// ProvisionSnippet is a PowerShell snippet from the provision.d directory,
+// rendered for a session.
+type ProvisionSnippet struct {
+	Name   string
+	Script string
+}
+
+// provisionFuncs are the functions snippets can use on top of the standard
+// template functions. secret looks up a secret source, such as
+// {{ secret "pass:tailscale/authkey" }}, so that it doesn't have to be kept in
+// the snippet, and psQuote quotes a value as a PowerShell string literal.
+//
+// A secret is only kept out of the snippet file. Once resolved it is part of
+// the user data, which is stored in the Terraform state, remote state
+// included, and in saved plans, and which anyone allowed to call
+// DescribeInstanceAttribute on the instance can read.
+func provisionFuncs(ctx context.Context) template.FuncMap {
+	return template.FuncMap{
+		"secret": func(spec string) (string, error) {
+			source, err := NewSecretSource(spec, "")
+			if err != nil {
+				return "", err
+			}
+			return source.Secret(ctx)
+		},
+		"psQuote": psQuote,
+	}
+}
+
+// psQuote quotes s as a single quoted PowerShell string, in which nothing is
+// expanded.
+func psQuote(s string) string {
+	return fmt.Sprintf("'%s'", strings.Replace(s, "'", "''", -1))
+}
+
+// ProvisionDir is where the user's provisioning snippets are kept.
+func (c *Client) ProvisionDir() string {
+	return fmt.Sprintf("%s/%s", c.Dir, ProvisionSnippetsDir)
+}
+
+// ProvisionSnippets reads the .ps1 files in the provision.d directory, in
+// order of their names, and renders them as Go templates for a session.
+// There are no snippets if the directory doesn't exist.
+func (c *Client) ProvisionSnippets(ctx context.Context, p *Session) ([]ProvisionSnippet, error) {
+	files, err := ioutil.ReadDir(c.ProvisionDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	vars := ProvisionVars{
+		Session:            p.Session,
+		User:               p.User,
+		Region:             p.Region,
+		AvailabilityZone:   p.AvailabilityZone,
+		InstanceType:       p.InstanceType,
+		Tags:               p.Tags,
+		Volumes:            p.Volumes.withDefaults(),
+		Host:               p.HostSettings.withDefaults(),
+		MaxSessionMinutes:  p.MaxSessionMinutes,
+		IdleTimeoutMinutes: p.IdleTimeoutMinutes,
+	}
+
+	var snippets []ProvisionSnippet
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".ps1" {
+			continue
+		}
+
+		path := fmt.Sprintf("%s/%s", c.ProvisionDir(), file.Name())
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := template.New(file.Name()).Funcs(provisionFuncs(ctx)).Option("missingkey=error").Parse(string(source))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read the provisioning snippet %s: %s", path, err)
+		}
+
+		var script bytes.Buffer
+		if err := tmpl.Execute(&script, vars); err != nil {
+			return nil, fmt.Errorf("Could not render the provisioning snippet %s: %s", path, err)
+		}
+
+		snippets = append(snippets, ProvisionSnippet{Name: file.Name(), Script: script.String()})
+	}
+
+	return snippets, nil
+}
+
+// provisionScript joins the snippets into the PowerShell that user_data.tmpl
+// runs after Parsec has been configured. Each snippet runs in its own script
+// block, which stops at the first error without stopping the snippets after
+// it, and reports its progress to the console.
+//
+// The user data runs on every boot, so a snippet that succeeds leaves a
+// marker in ProvisionedDir and is skipped from then on. A snippet that fails
+// is tried again on the next boot.
+func provisionScript(snippets []ProvisionSnippet) string {
+	if len(snippets) == 0 {
+		return ""
+	}
+
+	var script strings.Builder
+
+	fmt.Fprintf(&script, "  New-Item -ItemType Directory -Force -Path %s | Out-Null\n", psQuote(ProvisionedDir))
+
+	for _, snippet := range snippets {
+		name := psQuote(snippet.Name)
+
+		fmt.Fprintf(&script, "\n  # %s/%s\n", ProvisionSnippetsDir, snippet.Name)
+		fmt.Fprintf(&script, "  $marker = Join-Path %s %s\n", psQuote(ProvisionedDir), name)
+		fmt.Fprintf(&script, "  if (Test-Path -LiteralPath $marker) {\n    Send-ParsecEC2Progress %s %s \"already run on an earlier boot\"\n  } else {\n", ProvisionDone, name)
+		fmt.Fprintf(&script, "    Send-ParsecEC2Progress %s %s\n", ProvisionStarted, name)
+		fmt.Fprintf(&script, "    try {\n      & {\n$ErrorActionPreference = \"Stop\"\n%s\n      }\n", strings.TrimRight(snippet.Script, "\r\n"))
+		fmt.Fprintf(&script, "      New-Item -ItemType File -Force -Path $marker | Out-Null\n")
+		fmt.Fprintf(&script, "      Send-ParsecEC2Progress %s %s\n", ProvisionDone, name)
+		fmt.Fprintf(&script, "    } catch {\n      Send-ParsecEC2Progress %s %s $_\n    }\n  }\n", ProvisionFailed, name)
+	}
+
+	return script.String()
+}
+
+// provision renders the provisioning snippets for a prepared session and
+// checks that the user data they end up in fits in MaxUserDataSize.
+func (c *Client) provision(ctx context.Context, p *Session) error {
+	snippets, err := c.ProvisionSnippets(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	p.Provision = provisionScript(snippets)
+
+	p.ProvisionSnippets = nil
+	for _, snippet := range snippets {
+		p.ProvisionSnippets = append(p.ProvisionSnippets, snippet.Name)
+	}
+
+	size, err := c.UserDataSize(p)
+	if err != nil {
+		return err
+	}
+
+	if size > MaxUserDataSize {
+		return &ErrUserDataTooLarge{Size: size, Snippets: p.ProvisionSnippets}
+	}
+
+	return nil
+}
+
+// UserDataSize is the size of the user data a session is launched with:
+// user_data.tmpl rendered the way Terraform's template_file renders it.
+func (c *Client) UserDataSize(p *Session) (int, error) {
+	tmpl, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", c.Dir, Userdata))
+	if err != nil {
+		return 0, err
+	}
+
+	if !bytes.Contains(tmpl, []byte("${provision}")) && len(p.Provision) > 0 {
+		return 0, errors.New("The user_data.tmpl in use doesn't run provisioning snippets. Run 'parsec-ec2 init' to update it.")
+	}
+
+	userData := strings.NewReplacer(
+		"$${", "${",
+		"${server_key}", p.ServerKey,
+		"${max_session_minutes}", strconv.Itoa(p.MaxSessionMinutes),
+		"${idle_timeout_minutes}", strconv.Itoa(p.IdleTimeoutMinutes),
+		"${watchdog_grace_minutes}", strconv.Itoa(p.WatchdogGraceMinutes),
+		"${provision}", p.Provision,
+		"${parsec_start_port}", strconv.Itoa(p.HostSettings.withDefaults().StartPort),
+		"${parsec_config}", p.ConfigLines(),
+	).Replace(string(tmpl))
+
+	return len(userData), nil
+}
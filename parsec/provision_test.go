@@ -0,0 +1,223 @@
+package parsec
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newProvisionTestClient(t *testing.T, snippets map[string]string) (*Client, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := ioutil.ReadFile(fmt.Sprintf("../%s", Userdata))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, Userdata), tmpl, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	c := NewClient(dir)
+
+	if snippets != nil {
+		if err := os.Mkdir(c.ProvisionDir(), 0700); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	for name, source := range snippets {
+		if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", c.ProvisionDir(), name), []byte(source), 0600); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+
+	return c, func() { os.RemoveAll(dir) }
+}
+
+func TestProvisionSnippets(t *testing.T) {
+	c, cleanup := newProvisionTestClient(t, map[string]string{
+		"20-second.ps1": `Write-Output {{ psQuote .Session }}`,
+		"10-first.ps1":  `{{ if .Volumes.HasDataVolume }}Initialize-Disk {{ .Volumes.DataVolumeSize }}{{ end }}`,
+		"notes.txt":     `{{ .Nonsense }}`,
+	})
+	defer cleanup()
+
+	p := &Session{Session: "jade's session", Region: "eu-west-1"}
+
+	snippets, err := c.ProvisionSnippets(context.Background(), p)
+	if err != nil {
+		t.Fatalf("ProvisionSnippets() error = %v", err)
+	}
+
+	want := []ProvisionSnippet{
+		{Name: "10-first.ps1", Script: fmt.Sprintf("Initialize-Disk %d", DataVolumeSize)},
+		{Name: "20-second.ps1", Script: `Write-Output 'jade''s session'`},
+	}
+
+	if len(snippets) != len(want) {
+		t.Fatalf("ProvisionSnippets() = %+v, want %+v", snippets, want)
+	}
+	for i := range want {
+		if snippets[i] != want[i] {
+			t.Errorf("ProvisionSnippets()[%d] = %+v, want %+v", i, snippets[i], want[i])
+		}
+	}
+}
+
+func TestProvisionSnippetsErrors(t *testing.T) {
+	for name, source := range map[string]string{
+		"missing variable": `{{ .Nonsense }}`,
+		"bad template":     `{{ if }}`,
+		"bad secret":       `{{ secret "vault:x" }}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, cleanup := newProvisionTestClient(t, map[string]string{"10-bad.ps1": source})
+			defer cleanup()
+
+			if _, err := c.ProvisionSnippets(context.Background(), &Session{}); err == nil {
+				t.Error("ProvisionSnippets() succeeded, want an error")
+			}
+		})
+	}
+
+	t.Run("no directory", func(t *testing.T) {
+		c, cleanup := newProvisionTestClient(t, nil)
+		defer cleanup()
+
+		snippets, err := c.ProvisionSnippets(context.Background(), &Session{})
+		if err != nil || snippets != nil {
+			t.Errorf("ProvisionSnippets() = %v, %v, want nothing", snippets, err)
+		}
+	})
+}
+
+func TestProvisionScript(t *testing.T) {
+	if got := provisionScript(nil); got != "" {
+		t.Errorf("provisionScript(nil) = %q, want nothing", got)
+	}
+
+	script := provisionScript([]ProvisionSnippet{
+		{Name: "10-steam.ps1", Script: "choco install steam\r\n"},
+		{Name: "20-it's.ps1", Script: "Write-Output 'hi'"},
+	})
+
+	for _, want := range []string{
+		fmt.Sprintf("New-Item -ItemType Directory -Force -Path '%s'", ProvisionedDir),
+		fmt.Sprintf("$marker = Join-Path '%s' '10-steam.ps1'", ProvisionedDir),
+		"if (Test-Path -LiteralPath $marker) {\n    Send-ParsecEC2Progress done '10-steam.ps1' \"already run on an earlier boot\"",
+		"Send-ParsecEC2Progress started '10-steam.ps1'",
+		"$ErrorActionPreference = \"Stop\"\nchoco install steam\n      }\n      New-Item -ItemType File -Force -Path $marker",
+		"Send-ParsecEC2Progress done '10-steam.ps1'",
+		"Send-ParsecEC2Progress failed '10-steam.ps1' $_",
+		"Send-ParsecEC2Progress started '20-it''s.ps1'",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("provisionScript() doesn't contain %q:\n%s", want, script)
+		}
+	}
+
+	// The marker is only left once the snippet has succeeded.
+	if strings.Index(script, "New-Item -ItemType File") < strings.Index(script, "choco install steam") {
+		t.Error("provisionScript() leaves the marker before running the snippet")
+	}
+
+	if strings.Count(script, "{") != strings.Count(script, "}") {
+		t.Errorf("provisionScript() has unbalanced braces:\n%s", script)
+	}
+}
+
+func TestUserDataSize(t *testing.T) {
+	c, cleanup := newProvisionTestClient(t, nil)
+	defer cleanup()
+
+	tmpl, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", c.Dir, Userdata))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Session{ServerKey: "0123456789abcdef", MaxSessionMinutes: 240, IdleTimeoutMinutes: 30, WatchdogGraceMinutes: 10}
+
+	base, err := c.UserDataSize(p)
+	if err != nil {
+		t.Fatalf("UserDataSize() error = %v", err)
+	}
+	if base <= 0 || base > MaxUserDataSize {
+		t.Fatalf("UserDataSize() = %d", base)
+	}
+	if base == len(tmpl) {
+		t.Error("UserDataSize() didn't fill in the template")
+	}
+
+	// Every byte of a snippet counts.
+	p.Provision = provisionScript([]ProvisionSnippet{{Name: "10-big.ps1", Script: strings.Repeat("x", 1000)}})
+	size, err := c.UserDataSize(p)
+	if err != nil {
+		t.Fatalf("UserDataSize() error = %v", err)
+	}
+	if size != base+len(p.Provision) {
+		t.Errorf("UserDataSize() with a snippet = %d, want %d", size, base+len(p.Provision))
+	}
+
+	// A template from before snippets were supported can't run them.
+	old := strings.Replace(string(tmpl), "${provision}", "", -1)
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", c.Dir, Userdata), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UserDataSize(p); err == nil {
+		t.Error("UserDataSize() with an old template succeeded, want an error")
+	}
+}
+
+func TestUserDataSizeFillsEveryVariable(t *testing.T) {
+	c, cleanup := newProvisionTestClient(t, nil)
+	defer cleanup()
+
+	// Each variable is replaced by a value of a known length, so any left
+	// over would show up as a difference in size.
+	tmpl, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", c.Dir, Userdata))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variables := strings.Count(string(tmpl), "${") - strings.Count(string(tmpl), "$${")
+	if variables == 0 {
+		t.Fatal("user_data.tmpl has no variables")
+	}
+
+	p := &Session{}
+	empty, err := c.UserDataSize(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered := len(tmpl)
+	for _, name := range []string{"server_key", "max_session_minutes", "idle_timeout_minutes", "watchdog_grace_minutes", "provision", "parsec_start_port", "parsec_config"} {
+		placeholder := fmt.Sprintf("${%s}", name)
+		rendered -= strings.Count(string(tmpl), placeholder) * len(placeholder)
+		variables -= strings.Count(string(tmpl), placeholder)
+	}
+
+	if variables != 0 {
+		t.Errorf("user_data.tmpl has %d variable(s) UserDataSize doesn't fill in", variables)
+	}
+
+	// The zero session fills in 0 for the three durations and the default
+	// start port.
+	rendered += 3*len("0") + len(fmt.Sprint(DefaultParsecStartPort))
+	if empty != rendered {
+		t.Errorf("UserDataSize() = %d, want %d", empty, rendered)
+	}
+}
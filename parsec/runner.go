@@ -0,0 +1,103 @@
+package parsec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// run runs a Terraform command to completion and returns what it wrote to
+// stdout. Success is judged by its exit status, so warnings on stderr don't
+// fail the run, but stderr is kept for the error if it does fail.
+//
+// Everything the command writes is appended to the log named logName, and is
+// streamed to c.Stdout and c.Stderr as it is written when c.Verbose is set.
+// The output of 'terraform plan' is always streamed to c.Stdout.
+func (c *Client) run(ctx context.Context, command *exec.Cmd, logName string) ([]byte, error) {
+	logPath := c.LogPath(logName)
+
+	log, err := c.openLog(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer log.Close()
+
+	// stdout and stderr are copied to the log from separate goroutines.
+	transcript := &lockedWriter{w: log}
+
+	var stdout, stderr bytes.Buffer
+	stdoutWriters := []io.Writer{&stdout}
+	stderrWriters := []io.Writer{&stderr, transcript}
+
+	// The values of every output, including sensitive ones, aren't logged.
+	subcommand := command.Args[1]
+	if subcommand != TfCmdOutput {
+		stdoutWriters = append(stdoutWriters, transcript)
+	}
+
+	if c.Verbose || subcommand == TfCmdPlan {
+		stdoutWriters = append(stdoutWriters, c.Stdout)
+	}
+	if c.Verbose {
+		stderrWriters = append(stderrWriters, c.Stderr)
+	}
+
+	command.Stdout = io.MultiWriter(stdoutWriters...)
+	command.Stderr = io.MultiWriter(stderrWriters...)
+
+	fmt.Fprintf(transcript, "==> %s %s\n", time.Now().UTC().Format(time.RFC3339), strings.Join(command.Args, " "))
+
+	err = command.Run()
+
+	exitCode := 0
+	if command.ProcessState != nil {
+		exitCode = command.ProcessState.ExitCode()
+	}
+	fmt.Fprintf(transcript, "==> exit status %d\n\n", exitCode)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, fmt.Errorf("Terraform %s was interrupted: %w", subcommand, ctxErr)
+	}
+
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil, &ErrTerraform{Cmd: subcommand, ExitCode: exitCode, Stderr: stderr.String(), Log: logPath}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// LogPath is where the Terraform output for the log named name is kept. Each
+// session has its own log, named after the session, and 'terraform init'
+// logs to InitLog.
+func (c *Client) LogPath(name string) string {
+	return fmt.Sprintf("%s/%s/%s.log", c.Dir, Logs, name)
+}
+
+func (c *Client) openLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s", c.Dir, Logs), 0700); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
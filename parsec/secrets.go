@@ -0,0 +1,139 @@
+package parsec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	keyring "github.com/zalando/go-keyring"
+)
+
+// SecretSource looks up a secret, such as the Parsec server key, when it is
+// needed so that it doesn't have to be stored in a config or session file.
+type SecretSource interface {
+	Secret(ctx context.Context) (string, error)
+}
+
+// StaticSecret is a secret given directly, with a flag or an environment
+// variable.
+type StaticSecret string
+
+func (s StaticSecret) Secret(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// KeyringSecret is read from the OS keyring: the Keychain on macOS, the
+// Secret Service on Linux and the Credential Manager on Windows.
+type KeyringSecret struct {
+	Service string
+	User    string
+}
+
+func (k KeyringSecret) Secret(ctx context.Context) (string, error) {
+	secret, err := keyring.Get(k.Service, k.User)
+	if err == keyring.ErrNotFound {
+		return "", fmt.Errorf("There is no %s secret for %s in the keyring.", k.User, k.Service)
+	}
+	return secret, err
+}
+
+// Set stores secret in the OS keyring.
+func (k KeyringSecret) Set(secret string) error {
+	return keyring.Set(k.Service, k.User, secret)
+}
+
+// Delete removes the secret from the OS keyring.
+func (k KeyringSecret) Delete() error {
+	return keyring.Delete(k.Service, k.User)
+}
+
+// PassSecret is the first line of an entry in pass, the standard unix
+// password manager.
+type PassSecret struct {
+	Name string
+}
+
+func (p PassSecret) Secret(ctx context.Context) (string, error) {
+	output, err := runSecretCommand(exec.CommandContext(ctx, "pass", "show", p.Name))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.SplitN(output, "\n", 2)[0], nil
+}
+
+// CommandSecret is the output of a shell command, such as one that reads
+// from a password manager's CLI.
+type CommandSecret struct {
+	Command string
+}
+
+func (c CommandSecret) Secret(ctx context.Context) (string, error) {
+	var command *exec.Cmd
+	if runtime.GOOS == "windows" {
+		command = exec.CommandContext(ctx, "cmd", "/C", c.Command)
+	} else {
+		command = exec.CommandContext(ctx, "sh", "-c", c.Command)
+	}
+
+	return runSecretCommand(command)
+}
+
+func runSecretCommand(command *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("Could not read the secret with %s: %s %s", command.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Secret Source Kinds
+const (
+	SecretSourceKeyring = "keyring"
+	SecretSourcePass    = "pass"
+	SecretSourceCommand = "command"
+)
+
+// KeyringService is the service secrets are stored under in the OS keyring.
+const KeyringService = "parsec-ec2"
+
+// NewSecretSource parses a secret source given as kind:argument:
+//
+//	keyring               the name secret for KeyringService in the OS keyring
+//	keyring:<user>        the user secret for KeyringService in the OS keyring
+//	pass:<entry>          the first line of a pass entry
+//	command:<command>     the output of a shell command
+func NewSecretSource(spec, name string) (SecretSource, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case SecretSourceKeyring:
+		if len(arg) == 0 {
+			arg = name
+		}
+		return KeyringSecret{Service: KeyringService, User: arg}, nil
+	case SecretSourcePass:
+		if len(arg) == 0 {
+			return nil, errors.New("A pass secret source needs the name of an entry, e.g. pass:parsec/server_key.")
+		}
+		return PassSecret{Name: arg}, nil
+	case SecretSourceCommand:
+		if len(arg) == 0 {
+			return nil, errors.New("A command secret source needs a command to run, e.g. command:op read op://parsec/server_key.")
+		}
+		return CommandSecret{Command: arg}, nil
+	}
+
+	return nil, fmt.Errorf("%q is not a secret source, use keyring, pass:<entry> or command:<command>.", spec)
+}
@@ -1,8 +1,7 @@
-package cmd
+package parsec
 
 import (
-	"fmt"
-	"os"
+	"context"
 	"sort"
 	"strconv"
 	"time"
@@ -33,7 +32,7 @@ func (spotPriceHistory spotPriceHistory) Swap(i, j int) {
 	spotPriceHistory[i], spotPriceHistory[j] = spotPriceHistory[j], spotPriceHistory[i]
 }
 
-func getSpotPrice(svc *ec2.EC2, instanceType string) (ec2.SpotPrice, error) {
+func getSpotPrice(ctx context.Context, svc *ec2.EC2, instanceType string) (ec2.SpotPrice, error) {
 	instanceTypes := []*string{&instanceType}
 	productDescriptions := []*string{aws.String(Windows)}
 	startTime := time.Now().AddDate(0, 0, -1)
@@ -46,15 +45,14 @@ func getSpotPrice(svc *ec2.EC2, instanceType string) (ec2.SpotPrice, error) {
 		ProductDescriptions: productDescriptions,
 	}
 
-	result, err := svc.DescribeSpotPriceHistory(&describeSpotPriceHistoryInput)
+	result, err := svc.DescribeSpotPriceHistoryWithContext(ctx, &describeSpotPriceHistoryInput)
 
 	if err != nil {
 		return ec2.SpotPrice{}, err
 	}
 
 	if len(result.SpotPriceHistory) == 0 {
-		fmt.Printf("\n%s instances are not yet available in the requested region.\n", instanceType)
-		os.Exit(0)
+		return ec2.SpotPrice{}, &ErrInstanceTypeUnavailable{InstanceType: instanceType, Region: aws.StringValue(svc.Config.Region)}
 	}
 	sort.Reverse(spotPriceHistory(result.SpotPriceHistory))
 
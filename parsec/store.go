@@ -0,0 +1,207 @@
+package parsec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SessionStore keeps the current session next to the Terraform state, so that
+// whoever can reach the state can also find the session it belongs to.
+type SessionStore interface {
+	// Load returns ErrNoSession if there is no current session.
+	Load(ctx context.Context) ([]byte, error)
+	Save(ctx context.Context, b []byte) error
+	Remove(ctx context.Context) error
+}
+
+// sessionStore is the store matching the client's backend.
+func (c *Client) sessionStore() (SessionStore, error) {
+	if err := c.Backend.validate(); err != nil {
+		return nil, err
+	}
+
+	switch c.Backend.Type {
+	case BackendS3:
+		return c.newS3Store()
+	case BackendHTTP:
+		address := c.Backend.SessionAddress
+		if len(address) == 0 {
+			address = fmt.Sprintf("%s/%s", strings.TrimSuffix(c.Backend.Address, "/"), "session")
+		}
+		return HTTPStore{Address: address, Client: &http.Client{Timeout: HTTPStoreTimeout}}, nil
+	case BackendLocal:
+		// Terraform runs in the client's directory, so a relative path is
+		// relative to it.
+		path := c.Backend.Path
+		if !filepath.IsAbs(path) {
+			path = fmt.Sprintf("%s/%s", c.Dir, path)
+		}
+		return FileStore{Path: siblingPath(path, CurrentSession)}, nil
+	}
+
+	return FileStore{Path: fmt.Sprintf("%s/%s", c.Dir, CurrentSession)}, nil
+}
+
+// FileStore keeps the session in a file readable only by the user.
+type FileStore struct {
+	Path string
+}
+
+func (f FileStore) Load(ctx context.Context) ([]byte, error) {
+	b, err := ioutil.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSession
+	}
+	return b, err
+}
+
+func (f FileStore) Save(ctx context.Context, b []byte) error {
+	if err := ioutil.WriteFile(f.Path, b, 0600); err != nil {
+		return err
+	}
+
+	// WriteFile only applies the mode to new files, and session files used
+	// to be readable by everyone.
+	return os.Chmod(f.Path, 0600)
+}
+
+func (f FileStore) Remove(ctx context.Context) error {
+	return os.Remove(f.Path)
+}
+
+// S3Store keeps the session as an encrypted object in S3.
+type S3Store struct {
+	Bucket string
+	Key    string
+	Client *s3.S3
+}
+
+func (c *Client) newS3Store() (S3Store, error) {
+	b := c.Backend
+
+	sess, err := c.awsSession()
+	if err != nil {
+		return S3Store{}, err
+	}
+
+	return S3Store{
+		Bucket: b.Bucket,
+		Key:    siblingPath(b.Key, CurrentSession),
+		Client: s3.New(sess, &aws.Config{Region: aws.String(b.Region)}),
+	}, nil
+}
+
+func (s S3Store) Load(ctx context.Context) ([]byte, error) {
+	result, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	if isAWSErrorCode(err, s3.ErrCodeNoSuchKey) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	return ioutil.ReadAll(result.Body)
+}
+
+func (s S3Store) Save(ctx context.Context, b []byte) error {
+	_, err := s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(s.Key),
+		Body:                 bytes.NewReader(b),
+		ContentType:          aws.String("application/json"),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	return err
+}
+
+func (s S3Store) Remove(ctx context.Context) error {
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	return err
+}
+
+// HTTPStore keeps the session at a URL, which is read with GET, written with
+// POST and removed with DELETE, the same as Terraform's http backend.
+type HTTPStore struct {
+	Address string
+	Client  *http.Client
+}
+
+func (h HTTPStore) Load(ctx context.Context) ([]byte, error) {
+	resp, err := h.do(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return nil, ErrNoSession
+	}
+
+	// A failing server must not be mistaken for there being no session.
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", h.Address, resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Terraform's http backend treats an empty response as no state.
+	if len(b) == 0 {
+		return nil, ErrNoSession
+	}
+
+	return b, nil
+}
+
+func (h HTTPStore) Save(ctx context.Context, b []byte) error {
+	return h.send(ctx, http.MethodPost, b)
+}
+
+func (h HTTPStore) Remove(ctx context.Context) error {
+	return h.send(ctx, http.MethodDelete, nil)
+}
+
+func (h HTTPStore) send(ctx context.Context, method string, b []byte) error {
+	resp, err := h.do(ctx, method, b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s returned status %d", method, h.Address, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (h HTTPStore) do(ctx context.Context, method string, b []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.Address, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	if b != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return h.Client.Do(req)
+}
@@ -0,0 +1,178 @@
+package parsec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSessionStorePaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend Backend
+		want    string
+	}{
+		{name: "default", backend: Backend{}, want: "/home/jade/.parsec-ec2/currentSession.json"},
+		{name: "absolute local path", backend: Backend{Type: BackendLocal, Path: "/mnt/shared/parsec/terraform.tfstate"}, want: "/mnt/shared/parsec/currentSession.json"},
+		{name: "relative local path", backend: Backend{Type: BackendLocal, Path: "state/terraform.tfstate"}, want: "/home/jade/.parsec-ec2/state/currentSession.json"},
+		{name: "bare local file name", backend: Backend{Type: BackendLocal, Path: "terraform.tfstate"}, want: "/home/jade/.parsec-ec2/currentSession.json"},
+		{name: "http", backend: Backend{Type: BackendHTTP, Address: "https://state.example.com/parsec/"}, want: "https://state.example.com/parsec/session"},
+		{name: "http session address", backend: Backend{Type: BackendHTTP, Address: "https://state.example.com/parsec", SessionAddress: "https://state.example.com/session"}, want: "https://state.example.com/session"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient("/home/jade/.parsec-ec2")
+			c.Backend = tt.backend
+
+			store, err := c.sessionStore()
+			if err != nil {
+				t.Fatalf("sessionStore() error = %v", err)
+			}
+
+			var got string
+			switch s := store.(type) {
+			case FileStore:
+				got = s.Path
+			case HTTPStore:
+				got = s.Address
+				if s.Client == http.DefaultClient || s.Client.Timeout != HTTPStoreTimeout {
+					t.Errorf("HTTPStore client timeout = %s, want %s", s.Client.Timeout, HTTPStoreTimeout)
+				}
+			default:
+				t.Fatalf("sessionStore() = %T", store)
+			}
+
+			if got != tt.want {
+				t.Errorf("sessionStore() at %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	path := fmt.Sprintf("%s/%s", dir, CurrentSession)
+	store := FileStore{Path: path}
+
+	if _, err := store.Load(ctx); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Load() of a missing file error = %v, want ErrNoSession", err)
+	}
+
+	// Session files used to be readable by everyone.
+	if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Save(ctx, []byte(`{"session":"weeknight"}`)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode = %o, want 600", mode)
+	}
+
+	b, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := string(b); got != `{"session":"weeknight"}` {
+		t.Errorf("Load() = %s", got)
+	}
+
+	if err := store.Remove(ctx); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := store.Load(ctx); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Load() after Remove() error = %v, want ErrNoSession", err)
+	}
+}
+
+func TestHTTPStore(t *testing.T) {
+	var stored []byte
+	status := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if stored == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(stored)
+		case http.MethodPost:
+			if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			stored, _ = ioutil.ReadAll(r.Body)
+		case http.MethodDelete:
+			stored = nil
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store := HTTPStore{Address: server.URL, Client: server.Client()}
+
+	if _, err := store.Load(ctx); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Load() of nothing error = %v, want ErrNoSession", err)
+	}
+
+	if err := store.Save(ctx, []byte(`{"session":"weeknight"}`)); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	b, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := string(b); got != `{"session":"weeknight"}` {
+		t.Errorf("Load() = %s", got)
+	}
+
+	if err := store.Remove(ctx); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := store.Load(ctx); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Load() after Remove() error = %v, want ErrNoSession", err)
+	}
+
+	// Terraform's http backend treats an empty response as no state.
+	stored = []byte{}
+	if _, err := store.Load(ctx); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Load() of an empty body error = %v, want ErrNoSession", err)
+	}
+
+	status = http.StatusInternalServerError
+	if _, err := store.Load(ctx); err == nil || errors.Is(err, ErrNoSession) {
+		t.Errorf("Load() from a failing server error = %v, want a status error", err)
+	}
+	if err := store.Save(ctx, []byte("{}")); err == nil {
+		t.Error("Save() to a failing server succeeded")
+	}
+	if err := store.Remove(ctx); err == nil {
+		t.Error("Remove() from a failing server succeeded")
+	}
+}
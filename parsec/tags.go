@@ -0,0 +1,127 @@
+package parsec
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// sessionTags are the tags put on every resource a session creates. Extra
+// tags are included, but can't replace the tags parsec-ec2 relies on to find
+// its own resources.
+func sessionTags(extra map[string]string, session, user string, created time.Time) map[string]string {
+	tags := map[string]string{}
+
+	for key, value := range extra {
+		tags[key] = value
+	}
+
+	tags[TagSession] = session
+	tags[TagUser] = user
+	tags[TagCreated] = created.UTC().Format(time.RFC3339)
+	tags[TagVersion] = Version
+
+	return tags
+}
+
+// sessionNamePattern matches the session names that can be used in file names
+// and in the name of the session's security group.
+var sessionNamePattern = regexp.MustCompile(fmt.Sprintf(`^[A-Za-z0-9][A-Za-z0-9._-]{0,%d}$`, MaxSessionNameLength-1))
+
+// sessionNameChars matches the characters that can't be used in session names.
+var sessionNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func validateSessionName(name string) error {
+	if !sessionNamePattern.MatchString(name) {
+		return &ErrInvalidSessionName{Name: name}
+	}
+
+	return nil
+}
+
+// defaultSessionName is <user>-<timestamp>, with the user reduced to the
+// characters session names allow. Windows users are DOMAIN\user, and the
+// domain is left out.
+func defaultSessionName(user string, t time.Time) string {
+	timestamp := t.UTC().Format("20060102-150405")
+
+	if i := strings.LastIndex(user, `\`); i >= 0 {
+		user = user[i+1:]
+	}
+
+	user = strings.TrimLeft(sessionNameChars.ReplaceAllString(user, "-"), "._-")
+	if limit := MaxSessionNameLength - len(timestamp) - 1; len(user) > limit {
+		user = user[:limit]
+	}
+
+	if len(user) == 0 {
+		return fmt.Sprintf("%s-%s", LegacySessionName, timestamp)
+	}
+
+	return fmt.Sprintf("%s-%s", user, timestamp)
+}
+
+// hclMap formats tags as an HCL map, with its keys sorted, for the tfvars
+// file.
+func hclMap(m map[string]string) string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s = %s", strconv.Quote(key), strconv.Quote(m[key])))
+	}
+
+	return fmt.Sprintf("{ %s }", strings.Join(pairs, ", "))
+}
+
+// hclList formats values as an HCL list for the tfvars file.
+func hclList(values []string) string {
+	var quoted []string
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+
+	return fmt.Sprintf("[%s]", strings.Join(quoted, ", "))
+}
+
+// propagateTags copies the session tags to a fulfilled spot instance and its
+// volumes, since tags on a spot request are not passed on to the instance it
+// launches. Tagging is idempotent, so this is safe to call repeatedly.
+func propagateTags(ctx context.Context, svc *ec2.EC2, instanceID string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	resources := []*string{aws.String(instanceID)}
+
+	volumes, err := svc.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("attachment.instance-id"), Values: []*string{aws.String(instanceID)}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, volume := range volumes.Volumes {
+		resources = append(resources, volume.VolumeId)
+	}
+
+	var ec2Tags []*ec2.Tag
+	for key, value := range tags {
+		ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	ec2Tags = append(ec2Tags, &ec2.Tag{Key: aws.String(TagName), Value: aws.String(InstanceName)})
+
+	_, err = svc.CreateTagsWithContext(ctx, &ec2.CreateTagsInput{Resources: resources, Tags: ec2Tags})
+	return err
+}
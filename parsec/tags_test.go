@@ -0,0 +1,68 @@
+package parsec
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSessionName(t *testing.T) {
+	valid := []string{"weeknight", "jade-20200106-183000", "a", "v1.2_test", strings.Repeat("a", MaxSessionNameLength)}
+	for _, name := range valid {
+		if err := validateSessionName(name); err != nil {
+			t.Errorf("validateSessionName(%q) error = %v", name, err)
+		}
+	}
+
+	invalid := []string{"", "../../x", "a/b", `DOMAIN\jade`, "-leading", ".hidden", "with space", strings.Repeat("a", MaxSessionNameLength+1)}
+	for _, name := range invalid {
+		if err := validateSessionName(name); err == nil {
+			t.Errorf("validateSessionName(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestDefaultSessionName(t *testing.T) {
+	now := time.Date(2020, 1, 6, 18, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"jade", "jade-20200106-183000"},
+		{`DOMAIN\jade`, "jade-20200106-183000"},
+		{"jade iqbal", "jade-iqbal-20200106-183000"},
+		{"../jade", "jade-20200106-183000"},
+		{"", "session-20200106-183000"},
+		{strings.Repeat("a", 100), strings.Repeat("a", 48) + "-20200106-183000"},
+	}
+
+	for _, tt := range tests {
+		got := defaultSessionName(tt.user, now)
+		if got != tt.want {
+			t.Errorf("defaultSessionName(%q) = %q, want %q", tt.user, got, tt.want)
+		}
+		if err := validateSessionName(got); err != nil {
+			t.Errorf("defaultSessionName(%q) = %q, which is invalid: %v", tt.user, got, err)
+		}
+	}
+}
+
+func TestSessionFileName(t *testing.T) {
+	tests := []struct {
+		session string
+		want    string
+	}{
+		{"", LegacySessionName},
+		{"weeknight", "weeknight"},
+		{"../../x", "x-session"},
+		{`DOMAIN\jade-20200106-183000`, "DOMAIN-jade-20200106-183000-session"},
+	}
+
+	for _, tt := range tests {
+		p := Session{Session: tt.session}
+		if got := p.fileName(); got != tt.want {
+			t.Errorf("fileName() for %q = %q, want %q", tt.session, got, tt.want)
+		}
+	}
+}
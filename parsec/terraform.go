@@ -0,0 +1,241 @@
+package parsec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Session holds the Terraform variables a session was started with, and is
+// persisted to currentSession.json so that it can be inspected and stopped.
+// The server key is only held in memory while the spot request is made, and
+// is never persisted.
+type Session struct {
+	AMI                  string            `json:"ami"`
+	AvailabilityZone     string            `json:"availability_zone"`
+	AvailabilityZones    []string          `json:"availability_zones"`
+	Bid                  float64           `json:"bid"`
+	CreateVpc            bool              `json:"create_vpc"`
+	IdleTimeoutMinutes   int               `json:"idle_timeout_minutes"`
+	IP                   string            `json:"ip"`
+	InstanceType         string            `json:"instance_type"`
+	LaunchTime           time.Time         `json:"launch_time"`
+	MaxSessionMinutes    int               `json:"max_session_minutes"`
+	Pending              bool              `json:"pending,omitempty"`
+	Region               string            `json:"region"`
+	Provision            string            `json:"-"`
+	ProvisionSnippets    []string          `json:"provision_snippets,omitempty"`
+	ServerKey            string            `json:"-"`
+	Session              string            `json:"session"`
+	SpotPrice            string            `json:"spot_price"`
+	SubnetID             string            `json:"subnet_id"`
+	Tags                 map[string]string `json:"tags"`
+	User                 string            `json:"user"`
+	VpcID                string            `json:"vpc_id"`
+	WatchdogGraceMinutes int               `json:"watchdog_grace_minutes,omitempty"`
+
+	Volumes
+	HostSettings
+}
+
+type TfOutputs struct {
+	InstanceType struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"instance_type"`
+	Region struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"region"`
+	SpotRequestID struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"spot_request_id"`
+	SpotInstanceID struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"spot_instance_id"`
+	SpotBidStatus struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"spot_bid_status"`
+	SpotPrice struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"spot_price"`
+	SubnetID struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"subnet_id"`
+	VpcID struct {
+		Sensitive bool   `json:"sensitive"`
+		Type      string `json:"type"`
+		Value     string `json:"value"`
+	} `json:"vpc_id"`
+}
+
+func (c *Client) writeSession(ctx context.Context, p *Session) error {
+	store, err := c.sessionStore()
+	if err != nil {
+		return err
+	}
+
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return store.Save(ctx, bytes)
+}
+
+// Session reads the current session from the backend's session store,
+// returning ErrNoSession if there isn't one.
+func (c *Client) Session(ctx context.Context) (*Session, error) {
+	store, err := c.sessionStore()
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Session
+	if err := json.Unmarshal(bytes, &p); err != nil {
+		return nil, err
+	}
+
+	// Session files written by older versions held the server key, which is
+	// dropped by writing the session again.
+	if strings.Contains(string(bytes), `"server_key"`) {
+		if err := c.writeSession(ctx, &p); err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+func (c *Client) removeSession(ctx context.Context) error {
+	store, err := c.sessionStore()
+	if err != nil {
+		return err
+	}
+
+	return store.Remove(ctx)
+}
+
+func (p *Session) calculate(ctx context.Context, ec2Client *ec2.EC2, opts StartOptions) error {
+	spotPrice, err := getSpotPrice(ctx, ec2Client, opts.InstanceType)
+	if err != nil {
+		return err
+	}
+
+	spotBid := calculateUserBid(*spotPrice.SpotPrice, opts.Bid)
+	availabilityZone := *spotPrice.AvailabilityZone
+
+	// A managed network is created by Terraform, with a subnet in every
+	// availability zone, so there is nothing to look up.
+	if opts.CreateVpc {
+		zones, err := getAvailabilityZones(ctx, ec2Client)
+		if err != nil {
+			return err
+		}
+
+		p.CreateVpc = true
+		p.AvailabilityZones = zones
+	} else {
+		p.VpcID, err = getVpcID(ctx, ec2Client, opts.VpcID)
+		if err != nil {
+			return err
+		}
+
+		p.SubnetID, err = getSubnetID(ctx, ec2Client, p.VpcID, availabilityZone)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+
+	user := opts.User
+	if len(user) == 0 {
+		user = CurrentUser()
+	}
+
+	sessionName := opts.Session
+	if len(sessionName) == 0 {
+		sessionName = defaultSessionName(user, now)
+	}
+
+	p.AvailabilityZone = availabilityZone
+	p.Bid = opts.Bid
+	p.Volumes = opts.Volumes.withDefaults()
+	p.HostSettings = opts.Host.withDefaults()
+	p.Session = sessionName
+	p.Tags = sessionTags(opts.Tags, sessionName, user, now)
+	p.InstanceType = opts.InstanceType
+	p.Region = opts.Region
+	p.SpotPrice = spotBid
+	p.User = user
+
+	resolver := opts.IPResolver
+	if resolver == nil {
+		resolver = NewIPResolver("", DefaultIPProviders(), DefaultSTUNServers(), DefaultIPTimeout)
+	}
+
+	ip, err := resolver.ResolveIP(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.IP = fmt.Sprintf("%s/%s", ip, "32")
+
+	if strings.Contains(opts.InstanceType, "g2.") {
+		p.AMI = "parsec-g2-*"
+	} else if strings.Contains(opts.InstanceType, "g3.") {
+		p.AMI = "parsec-g3-*"
+	}
+
+	return nil
+}
+
+func calculateUserBid(cheapestSpotPrice string, bidIncrease float64) string {
+	spotPrice, _ := strconv.ParseFloat(cheapestSpotPrice, 64)
+	userBid := spotPrice + bidIncrease
+
+	return fmt.Sprint(userBid)
+}
+
+func (c *Client) outputs(ctx context.Context, p *Session) (*TfOutputs, error) {
+	o, err := c.tfCmd(ctx, []string{TfCmdOutput, TfFlagJSON})
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := c.run(ctx, o, p.fileName())
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs TfOutputs
+	if err := json.Unmarshal(output, &outputs); err != nil {
+		return nil, err
+	}
+
+	return &outputs, nil
+}
@@ -0,0 +1,97 @@
+package parsec
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// tfVarsTemplate renders a session as a Terraform variables file matching the
+// variables in parsec.tf, apart from server_key.
+var tfVarsTemplate = template.Must(template.New("tfvars").Funcs(template.FuncMap{
+	"quote":   strconv.Quote,
+	"hclMap":  hclMap,
+	"hclList": hclList,
+}).Parse(`region                 = {{ quote .Region }}
+availability_zone      = {{ quote .AvailabilityZone }}
+availability_zones     = {{ hclList .AvailabilityZones }}
+vpc_id                 = {{ quote .VpcID }}
+subnet_id              = {{ quote .SubnetID }}
+create_vpc             = "{{ if .CreateVpc }}1{{ else }}0{{ end }}"
+instance_type          = {{ quote .InstanceType }}
+ami                    = {{ quote .AMI }}
+spot_price             = {{ quote .SpotPrice }}
+ip                     = {{ quote .IP }}
+max_session_minutes    = "{{ .MaxSessionMinutes }}"
+idle_timeout_minutes   = "{{ .IdleTimeoutMinutes }}"
+watchdog_grace_minutes = "{{ .WatchdogGraceMinutes }}"
+root_volume_size       = "{{ .RootVolumeSize }}"
+data_volume_count      = "{{ if .HasDataVolume }}1{{ else }}0{{ end }}"
+data_volume_size       = "{{ .DataVolumeSize }}"
+data_volume_type       = {{ quote .DataVolumeType }}
+data_volume_iops       = "{{ .DataVolumeIOPS }}"
+encrypt_volumes        = "{{ if .EncryptVolumes }}1{{ else }}0{{ end }}"
+kms_key_id             = {{ quote .KMSKeyID }}
+parsec_start_port      = "{{ .StartPort }}"
+parsec_end_port        = "{{ .EndPort }}"
+parsec_config          = {{ quote .ConfigLines }}
+session                = {{ quote .Session }}
+tags                   = {{ hclMap .Tags }}
+`))
+
+// TfVarsPath is where the variables for a session are written. The file is
+// kept after the session has stopped, so that the session can be reproduced
+// with 'terraform plan -var-file'.
+func (c *Client) TfVarsPath(p *Session) string {
+	return fmt.Sprintf("%s/%s/%s.tfvars", c.Dir, Sessions, p.fileName())
+}
+
+// writeTfVars writes the session's variables to path, readable only by the
+// user. The server key is left out, and is passed to Terraform in the
+// environment when it is needed.
+func (c *Client) writeTfVars(p *Session, path string) error {
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s", c.Dir, Sessions), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Sessions started by older versions don't record every volume or Parsec
+	// setting.
+	session := *p
+	session.Volumes = p.Volumes.withDefaults()
+	session.HostSettings = p.HostSettings.withDefaults()
+
+	if err := tfVarsTemplate.Execute(f, &session); err != nil {
+		f.Close()
+		return err
+	}
+
+	// OpenFile only applies the mode to new files.
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// fileName is the name of the session's tfvars file and log. Session names
+// are validated before a session is applied, but names that can't be used in
+// a path are replaced in case the session was saved before they were.
+func (p *Session) fileName() string {
+	if len(p.Session) == 0 {
+		return LegacySessionName
+	}
+
+	if validateSessionName(p.Session) != nil {
+		return strings.Trim(sessionNameChars.ReplaceAllString(p.Session, "-"), ".-") + "-" + LegacySessionName
+	}
+
+	return p.Session
+}
@@ -0,0 +1,194 @@
+package parsec
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// tfVar returns the line setting name in a rendered tfvars file.
+func tfVar(t *testing.T, tfvars, name string) string {
+	t.Helper()
+
+	for _, line := range strings.Split(tfvars, "\n") {
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == name {
+			return strings.TrimSpace(fields[1])
+		}
+	}
+
+	t.Fatalf("%s is not set in:\n%s", name, tfvars)
+	return ""
+}
+
+func TestTfVarsTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		session Session
+		want    map[string]string
+	}{
+		{
+			name: "existing network",
+			session: Session{
+				Region:           "eu-west-1",
+				AvailabilityZone: "eu-west-1a",
+				VpcID:            "vpc-123",
+				SubnetID:         "subnet-123",
+				SpotPrice:        "0.5",
+				IP:               "203.0.113.7/32",
+				Session:          "weeknight",
+			},
+			want: map[string]string{
+				"region":             `"eu-west-1"`,
+				"vpc_id":             `"vpc-123"`,
+				"create_vpc":         `"0"`,
+				"availability_zones": `[]`,
+				"ip":                 `"203.0.113.7/32"`,
+				"session":            `"weeknight"`,
+				"tags":               `{  }`,
+			},
+		},
+		{
+			name: "managed network",
+			session: Session{
+				CreateVpc:         true,
+				AvailabilityZones: []string{"eu-west-1a", "eu-west-1b"},
+			},
+			want: map[string]string{
+				"create_vpc":         `"1"`,
+				"availability_zones": `["eu-west-1a", "eu-west-1b"]`,
+				"vpc_id":             `""`,
+			},
+		},
+		{
+			name: "volumes",
+			session: Session{
+				Volumes: Volumes{RootVolumeSize: 60, DataVolumeSize: 200, DataVolumeType: VolumeTypeIO1, DataVolumeIOPS: 4000, EncryptVolumes: true, KMSKeyID: "alias/parsec"},
+			},
+			want: map[string]string{
+				"root_volume_size":  `"60"`,
+				"data_volume_count": `"1"`,
+				"data_volume_size":  `"200"`,
+				"data_volume_type":  `"io1"`,
+				"data_volume_iops":  `"4000"`,
+				"encrypt_volumes":   `"1"`,
+				"kms_key_id":        `"alias/parsec"`,
+			},
+		},
+		{
+			name:    "no data volume",
+			session: Session{Volumes: Volumes{DataVolumeType: VolumeTypeNone}},
+			want: map[string]string{
+				"data_volume_count": `"0"`,
+				"encrypt_volumes":   `"0"`,
+			},
+		},
+		{
+			name:    "watchdog",
+			session: Session{MaxSessionMinutes: 240, IdleTimeoutMinutes: 30, WatchdogGraceMinutes: 10},
+			want: map[string]string{
+				"max_session_minutes":    `"240"`,
+				"idle_timeout_minutes":   `"30"`,
+				"watchdog_grace_minutes": `"10"`,
+			},
+		},
+		{
+			name:    "host settings",
+			session: Session{HostSettings: HostSettings{StartPort: 9000, Bitrate: 20, HostName: "gaming"}},
+			want: map[string]string{
+				"parsec_start_port": `"9000"`,
+				"parsec_end_port":   `"9040"`,
+				"parsec_config":     `"encoder_bitrate=20\nhost_name=gaming"`,
+			},
+		},
+		{
+			name:    "quoting",
+			session: Session{Session: `a"b\c`, Tags: map[string]string{"team": "games", "owner": `jade "j"`}},
+			want: map[string]string{
+				"session": `"a\"b\\c"`,
+				"tags":    `{ "owner" = "jade \"j\"", "team" = "games" }`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := tfVarsTemplate.Execute(&b, &tt.session); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			for name, want := range tt.want {
+				if got := tfVar(t, b.String(), name); got != want {
+					t.Errorf("%s = %s, want %s", name, got, want)
+				}
+			}
+
+			if strings.Contains(b.String(), "server_key") {
+				t.Error("the server key was written to the tfvars file")
+			}
+		})
+	}
+}
+
+func TestWriteTfVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parsec-ec2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewClient(dir)
+	p := &Session{Session: "weeknight", ServerKey: "secret"}
+	path := c.TfVarsPath(p)
+
+	if want := fmt.Sprintf("%s/%s/weeknight.tfvars", dir, Sessions); path != want {
+		t.Errorf("TfVarsPath() = %s, want %s", path, want)
+	}
+
+	// A file left readable by an older version is tightened when rewritten.
+	if err := os.MkdirAll(fmt.Sprintf("%s/%s", dir, Sessions), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.writeTfVars(p, path); err != nil {
+		t.Fatalf("writeTfVars() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode = %o, want 600", mode)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions saved by older versions get the defaults.
+	tfvars := string(contents)
+	for name, want := range map[string]string{
+		"root_volume_size":  fmt.Sprintf(`"%d"`, RootVolumeSize),
+		"data_volume_type":  fmt.Sprintf("%q", VolumeTypeGP2),
+		"data_volume_size":  fmt.Sprintf(`"%d"`, DataVolumeSize),
+		"parsec_start_port": fmt.Sprintf(`"%d"`, DefaultParsecStartPort),
+		"parsec_end_port":   fmt.Sprintf(`"%d"`, DefaultParsecStartPort+ParsecPortRange),
+	} {
+		if got := tfVar(t, tfvars, name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+
+	if strings.Contains(tfvars, "secret") {
+		t.Error("the server key was written to the tfvars file")
+	}
+}
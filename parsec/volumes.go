@@ -0,0 +1,166 @@
+package parsec
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Volumes describes the EBS volumes of a session's instance: the root volume
+// Windows and Parsec run from, and a data volume for games. The zero value is
+// a 50 GB root volume and a 100 GB gp2 data volume, neither encrypted.
+type Volumes struct {
+	// RootVolumeSize and DataVolumeSize are in GB.
+	RootVolumeSize int `json:"root_volume_size,omitempty"`
+	DataVolumeSize int `json:"data_volume_size,omitempty"`
+
+	// DataVolumeType is one of the VolumeType constants.
+	DataVolumeType string `json:"data_volume_type,omitempty"`
+
+	// DataVolumeIOPS is provisioned for an io1 data volume, and zero means
+	// DefaultIO1IOPS. DataVolumeThroughput, in MiB/s, can only be provisioned
+	// for gp3 volumes, which sessions can't use, so it is always refused.
+	DataVolumeIOPS       int `json:"data_volume_iops,omitempty"`
+	DataVolumeThroughput int `json:"data_volume_throughput,omitempty"`
+
+	// EncryptVolumes encrypts both volumes, with KMSKeyID if it is set and
+	// the account's default EBS key otherwise. Setting KMSKeyID implies
+	// EncryptVolumes.
+	EncryptVolumes bool   `json:"encrypt_volumes,omitempty"`
+	KMSKeyID       string `json:"kms_key_id,omitempty"`
+}
+
+// kmsKeyPattern matches the forms EC2 accepts for a KMS key: a key id, an
+// alias, or the ARN of either.
+var kmsKeyPattern = regexp.MustCompile(`^(arn:aws[a-z-]*:kms:[a-z0-9-]+:\d{12}:(key/|alias/).+|alias/.+|(mrk-)?[0-9a-f-]{32,36})$`)
+
+// withDefaults fills in the sizes, type and performance of volumes that
+// weren't given.
+func (v Volumes) withDefaults() Volumes {
+	if v.RootVolumeSize == 0 {
+		v.RootVolumeSize = RootVolumeSize
+	}
+
+	if len(v.DataVolumeType) == 0 {
+		v.DataVolumeType = VolumeTypeGP2
+	}
+
+	if v.DataVolumeType == VolumeTypeNone {
+		v.DataVolumeSize = 0
+	} else if v.DataVolumeSize == 0 {
+		v.DataVolumeSize = DataVolumeSize
+	}
+
+	if v.DataVolumeType == VolumeTypeIO1 && v.DataVolumeIOPS == 0 {
+		v.DataVolumeIOPS = DefaultIO1IOPS
+	}
+
+	if len(v.KMSKeyID) > 0 {
+		v.EncryptVolumes = true
+	}
+
+	return v
+}
+
+// HasDataVolume reports whether the instance gets a data volume.
+func (v Volumes) HasDataVolume() bool {
+	return v.DataVolumeType != VolumeTypeNone
+}
+
+// Validate checks the volumes against the limits EC2 puts on them, so that a
+// bad combination is caught before Terraform makes the spot request.
+func (v Volumes) Validate() error {
+	v = v.withDefaults()
+
+	if v.RootVolumeSize < MinRootVolumeSize || v.RootVolumeSize > MaxVolumeSize {
+		return fmt.Errorf("The root volume must be between %d and %d GB, not %d GB.", MinRootVolumeSize, MaxVolumeSize, v.RootVolumeSize)
+	}
+
+	switch v.DataVolumeType {
+	case VolumeTypeGP2, VolumeTypeIO1, VolumeTypeNone:
+	case VolumeTypeGP3, VolumeTypeIO2:
+		return fmt.Errorf("%s data volumes need version 3 of Terraform's AWS provider. Terraform 0.10 can't run it, so use %s, or %s for provisioned IOPS.", v.DataVolumeType, VolumeTypeGP2, VolumeTypeIO1)
+	default:
+		return fmt.Errorf("The data volume type must be one of %s, %s or %s, not %q.", VolumeTypeGP2, VolumeTypeIO1, VolumeTypeNone, v.DataVolumeType)
+	}
+
+	if v.DataVolumeThroughput != 0 {
+		return fmt.Errorf("Throughput can only be set for %s data volumes, which need version 3 of Terraform's AWS provider. Terraform 0.10 can't run it.", VolumeTypeGP3)
+	}
+
+	if !v.HasDataVolume() {
+		if v.DataVolumeIOPS != 0 {
+			return errors.New("IOPS can't be set without a data volume.")
+		}
+		return v.validateKMSKey()
+	}
+
+	minSize := 1
+	if v.DataVolumeType == VolumeTypeIO1 {
+		minSize = MinIO1VolumeSize
+	}
+
+	if v.DataVolumeSize < minSize || v.DataVolumeSize > MaxVolumeSize {
+		return fmt.Errorf("A %s data volume must be between %d and %d GB, not %d GB.", v.DataVolumeType, minSize, MaxVolumeSize, v.DataVolumeSize)
+	}
+
+	switch v.DataVolumeType {
+	case VolumeTypeGP2:
+		if v.DataVolumeIOPS != 0 {
+			return fmt.Errorf("IOPS can only be set for %s data volumes.", VolumeTypeIO1)
+		}
+	case VolumeTypeIO1:
+		if v.DataVolumeIOPS < MinIO1IOPS || v.DataVolumeIOPS > MaxIO1IOPS {
+			return fmt.Errorf("An %s data volume must have between %d and %d IOPS, not %d.", VolumeTypeIO1, MinIO1IOPS, MaxIO1IOPS, v.DataVolumeIOPS)
+		}
+		if v.DataVolumeIOPS > MaxIO1IOPSPerGB*v.DataVolumeSize {
+			return fmt.Errorf("A %d GB %s data volume can have at most %d IOPS, not %d.", v.DataVolumeSize, VolumeTypeIO1, MaxIO1IOPSPerGB*v.DataVolumeSize, v.DataVolumeIOPS)
+		}
+	}
+
+	return v.validateKMSKey()
+}
+
+func (v Volumes) validateKMSKey() error {
+	if len(v.KMSKeyID) > 0 && !kmsKeyPattern.MatchString(v.KMSKeyID) {
+		return fmt.Errorf("%s is not a KMS key id, alias or ARN.", v.KMSKeyID)
+	}
+
+	return nil
+}
+
+// CostPerHour is roughly what the volumes cost per hour in a region.
+func (v Volumes) CostPerHour(region string) float64 {
+	v = v.withDefaults()
+
+	// The root volume of the Parsec AMI is gp2.
+	monthly := ebsMonthlyCost(region, VolumeTypeGP2, v.RootVolumeSize, 0, 0)
+	if v.HasDataVolume() {
+		monthly += ebsMonthlyCost(region, v.DataVolumeType, v.DataVolumeSize, v.DataVolumeIOPS, v.DataVolumeThroughput)
+	}
+
+	return monthly / hoursPerMonth
+}
+
+// String describes the volumes, such as "50 GB root volume, 200 GB io1 data
+// volume (4000 IOPS), encrypted".
+func (v Volumes) String() string {
+	v = v.withDefaults()
+
+	s := fmt.Sprintf("%d GB root volume", v.RootVolumeSize)
+
+	switch v.DataVolumeType {
+	case VolumeTypeNone:
+		s += ", no data volume"
+	case VolumeTypeIO1:
+		s += fmt.Sprintf(", %d GB %s data volume (%d IOPS)", v.DataVolumeSize, v.DataVolumeType, v.DataVolumeIOPS)
+	default:
+		s += fmt.Sprintf(", %d GB %s data volume", v.DataVolumeSize, v.DataVolumeType)
+	}
+
+	if v.EncryptVolumes {
+		s += ", encrypted"
+	}
+
+	return s
+}
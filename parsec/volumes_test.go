@@ -0,0 +1,86 @@
+package parsec
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVolumesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes Volumes
+		err     string
+	}{
+		{name: "defaults", volumes: Volumes{}},
+		{name: "root volume too small", volumes: Volumes{RootVolumeSize: MinRootVolumeSize - 1}, err: "root volume"},
+		{name: "root volume too large", volumes: Volumes{RootVolumeSize: MaxVolumeSize + 1}, err: "root volume"},
+		{name: "unknown type", volumes: Volumes{DataVolumeType: "st1"}, err: "one of gp2, io1 or none"},
+		{name: "data volume too large", volumes: Volumes{DataVolumeSize: MaxVolumeSize + 1}, err: "between"},
+
+		{name: "no data volume", volumes: Volumes{DataVolumeType: VolumeTypeNone, DataVolumeSize: 500}},
+		{name: "no data volume with IOPS", volumes: Volumes{DataVolumeType: VolumeTypeNone, DataVolumeIOPS: 4000}, err: "without a data volume"},
+		{name: "no data volume with a bad key", volumes: Volumes{DataVolumeType: VolumeTypeNone, KMSKeyID: "my key"}, err: "KMS key"},
+
+		{name: "gp2 with IOPS", volumes: Volumes{DataVolumeType: VolumeTypeGP2, DataVolumeIOPS: 4000}, err: "only be set for io1"},
+
+		// Terraform 0.10 can only run version 2 of the AWS provider.
+		{name: "gp3", volumes: Volumes{DataVolumeType: VolumeTypeGP3}, err: "version 3"},
+		{name: "io2", volumes: Volumes{DataVolumeType: VolumeTypeIO2, DataVolumeIOPS: 4000}, err: "version 3"},
+		{name: "gp2 with throughput", volumes: Volumes{DataVolumeType: VolumeTypeGP2, DataVolumeThroughput: 250}, err: "version 3"},
+		{name: "no data volume with throughput", volumes: Volumes{DataVolumeType: VolumeTypeNone, DataVolumeThroughput: 250}, err: "version 3"},
+
+		{name: "io1 default", volumes: Volumes{DataVolumeType: VolumeTypeIO1}},
+		{name: "io1 provisioned", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeSize: 200, DataVolumeIOPS: 10000}},
+		{name: "io1 too small", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeSize: MinIO1VolumeSize - 1}, err: "between"},
+		{name: "io1 default IOPS above its size", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeSize: 50}, err: "at most 2500 IOPS"},
+		{name: "io1 too few IOPS", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeIOPS: MinIO1IOPS - 1}, err: "between"},
+		{name: "io1 too many IOPS", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeSize: 2000, DataVolumeIOPS: MaxIO1IOPS + 1}, err: "between"},
+		{name: "io1 with throughput", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeThroughput: 250}, err: "version 3"},
+
+		{name: "key id", volumes: Volumes{KMSKeyID: "1234abcd-12ab-34cd-56ef-1234567890ab"}},
+		{name: "key alias", volumes: Volumes{KMSKeyID: "alias/parsec"}},
+		{name: "key ARN", volumes: Volumes{KMSKeyID: "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"}},
+		{name: "bad key", volumes: Volumes{KMSKeyID: "my key"}, err: "KMS key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.volumes.Validate()
+			if len(tt.err) == 0 {
+				if err != nil {
+					t.Errorf("Validate() of %s error = %v", tt.volumes, err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Validate() of %s succeeded, want an error about %s", tt.volumes, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Validate() error = %q, want it to mention %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVolumesWithDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes Volumes
+		want    Volumes
+	}{
+		{name: "zero", want: Volumes{RootVolumeSize: RootVolumeSize, DataVolumeSize: DataVolumeSize, DataVolumeType: VolumeTypeGP2}},
+		{name: "io1", volumes: Volumes{DataVolumeType: VolumeTypeIO1}, want: Volumes{RootVolumeSize: RootVolumeSize, DataVolumeSize: DataVolumeSize, DataVolumeType: VolumeTypeIO1, DataVolumeIOPS: DefaultIO1IOPS}},
+		{name: "io1 provisioned", volumes: Volumes{DataVolumeType: VolumeTypeIO1, DataVolumeIOPS: 4000}, want: Volumes{RootVolumeSize: RootVolumeSize, DataVolumeSize: DataVolumeSize, DataVolumeType: VolumeTypeIO1, DataVolumeIOPS: 4000}},
+		{name: "none", volumes: Volumes{DataVolumeType: VolumeTypeNone, DataVolumeSize: 200}, want: Volumes{RootVolumeSize: RootVolumeSize, DataVolumeType: VolumeTypeNone}},
+		{name: "key implies encryption", volumes: Volumes{KMSKeyID: "alias/parsec"}, want: Volumes{RootVolumeSize: RootVolumeSize, DataVolumeSize: DataVolumeSize, DataVolumeType: VolumeTypeGP2, EncryptVolumes: true, KMSKeyID: "alias/parsec"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.volumes.withDefaults(); got != tt.want {
+				t.Errorf("withDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package parsec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WatchdogGrace is how long the instance is given to boot and be provisioned
+// before the watchdog starts counting it as idle, as no Parsec client can
+// connect until then. A shorter idle timeout would be used up by the grace
+// period, so it is also the minimum idle timeout.
+const WatchdogGrace = 10 * time.Minute
+
+// MinimumIdleTimeout is the shortest idle timeout the watchdog accepts.
+const MinimumIdleTimeout = WatchdogGrace
+
+// setWatchdog validates the session limits and stores them on the session in
+// whole minutes, which is what the watchdog provisioned by user_data.tmpl reads.
+func (p *Session) setWatchdog(maxDuration, idleTimeout time.Duration) error {
+	if maxDuration < 0 {
+		return errors.New("The maximum session duration can't be negative.")
+	}
+
+	if idleTimeout < 0 {
+		return errors.New("The idle timeout can't be negative.")
+	}
+
+	if idleTimeout > 0 && idleTimeout < MinimumIdleTimeout {
+		return fmt.Errorf("The idle timeout must be at least %s to allow for the instance to be provisioned.", MinimumIdleTimeout)
+	}
+
+	p.MaxSessionMinutes = int(maxDuration.Round(time.Minute) / time.Minute)
+	p.IdleTimeoutMinutes = int(idleTimeout.Round(time.Minute) / time.Minute)
+	p.WatchdogGraceMinutes = int(WatchdogGrace / time.Minute)
+
+	return nil
+}
+
+// WatchdogDeadline is when the watchdog will shut the instance down regardless
+// of whether anyone is connected, or the zero time if there is no limit.
+func (p *Session) WatchdogDeadline() time.Time {
+	if p.MaxSessionMinutes == 0 || p.LaunchTime.IsZero() {
+		return time.Time{}
+	}
+	return p.LaunchTime.Add(time.Duration(p.MaxSessionMinutes) * time.Minute)
+}